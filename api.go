@@ -6,6 +6,7 @@ import (
 
 type API interface {
 	Marshal(v interface{}) ([]byte, error)
+	AppendMarshal(dst []byte, v interface{}) ([]byte, error)
 	MarshalToString(v interface{}) (string, error)
 	Unmarshal(data []byte, v interface{}) error
 	UnmarshalFromString(str string, v interface{}) error
@@ -16,3 +17,22 @@ type Codec interface {
 	Encode(unsafe.Pointer, Writer) error
 	Decode(unsafe.Pointer, Reader) error
 }
+
+// Marshaler is implemented by types that write their own value, unescaped.
+type Marshaler interface {
+	MarshalNGX() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types that read their own value, unescaped.
+// UnmarshalNGX must copy data if it keeps it after returning.
+type Unmarshaler interface {
+	UnmarshalNGX(data []byte) error
+}
+
+// Generated is implemented by types whose Marshaler and Unmarshaler methods
+// are generated by cmd/ngxgen for the log format NGXFormat returns. An NGX
+// only uses them if it is compiled from that exact format, and decodes
+// without options.
+type Generated interface {
+	NGXFormat() string
+}
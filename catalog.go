@@ -0,0 +1,266 @@
+package ngx
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidValue = errors.New("Invalid variable value")
+
+var (
+	stringType   = reflect.TypeOf("")
+	intType      = reflect.TypeOf(0)
+	int64Type    = reflect.TypeOf(int64(0))
+	float64Type  = reflect.TypeOf(float64(0))
+	stringsType  = reflect.TypeOf([]string(nil))
+	intsType     = reflect.TypeOf([]int(nil))
+	int64sType   = reflect.TypeOf([]int64(nil))
+	float64sType = reflect.TypeOf([]float64(nil))
+)
+
+// A Variable describes a nginx variable: what its values look like, and the
+// Go type they are decoded into by default, e.g. in a map[string]interface{}.
+type Variable struct {
+	Name        string // a prefix such as "http_" if Prefix is true
+	Prefix      bool
+	HTTP        bool // available in the http module
+	Stream      bool // available in the stream module
+	Type        reflect.Type
+	Description string
+
+	grammar *grammar
+	list    bool // values are $upstream_* lists of grammar
+}
+
+// Validate reports whether value is a valid value of v. Empty values, and
+// the "-" nginx writes for a variable without a value, are always valid.
+func (v *Variable) Validate(value string) error {
+	return v.validate([]byte(value))
+}
+
+func (v *Variable) validate(value []byte) error {
+	if v.grammar == nil || len(value) == 0 {
+		return nil
+	}
+	if !v.list {
+		if !v.grammar.match(value) {
+			return fmt.Errorf("%w: %q for $%s", ErrInvalidValue, value, v.Name)
+		}
+		return nil
+	}
+	for rest := value; ; {
+		n, skip := indexSep(rest, upstreamSeps)
+		if !v.grammar.match(rest[:n]) {
+			return fmt.Errorf("%w: %q in %q for $%s", ErrInvalidValue, rest[:n], value, v.Name)
+		}
+		if skip == 0 {
+			return nil
+		}
+		rest = rest[n+skip:]
+	}
+}
+
+var (
+	grammarSSLProtocol = fixedOf("TLSv1.3", "TLSv1.2", "TLSv1.1", "TLSv1", "SSLv3", "SSLv2")
+	grammarFlag        = fixedOf("r", "p", ".")
+)
+
+// catalog lists the variables of the nginx http and stream modules that are
+// commonly logged, see http://nginx.org/en/docs/varindex.html.
+var catalog = []*Variable{
+	{Name: "arg_", Prefix: true, HTTP: true, Type: stringType, Description: "argument in the request line"},
+	{Name: "args", HTTP: true, Type: stringType, Description: "arguments in the request line"},
+	{Name: "binary_remote_addr", HTTP: true, Stream: true, Type: stringType, Description: "client address in a binary form"},
+	{Name: "body_bytes_sent", HTTP: true, Type: int64Type, Description: "number of bytes sent to a client, not counting the response header", grammar: grammarNumber},
+	{Name: "bytes_received", Stream: true, Type: int64Type, Description: "number of bytes received from a client", grammar: grammarNumber},
+	{Name: "bytes_sent", HTTP: true, Stream: true, Type: int64Type, Description: "number of bytes sent to a client", grammar: grammarNumber},
+	{Name: "connection", HTTP: true, Stream: true, Type: int64Type, Description: "connection serial number", grammar: grammarNumber},
+	{Name: "connection_requests", HTTP: true, Type: intType, Description: "current number of requests made through a connection", grammar: grammarNumber},
+	{Name: "connection_time", HTTP: true, Type: float64Type, Description: "connection time in seconds with a milliseconds resolution", grammar: grammarSeconds},
+	{Name: "content_length", HTTP: true, Type: int64Type, Description: "\"Content-Length\" request header field", grammar: grammarNumber},
+	{Name: "content_type", HTTP: true, Type: stringType, Description: "\"Content-Type\" request header field"},
+	{Name: "cookie_", Prefix: true, HTTP: true, Type: stringType, Description: "named cookie"},
+	{Name: "document_root", HTTP: true, Type: stringType, Description: "root or alias directive's value for the current request"},
+	{Name: "document_uri", HTTP: true, Type: stringType, Description: "same as $uri"},
+	{Name: "gzip_ratio", HTTP: true, Type: float64Type, Description: "achieved compression ratio"},
+	{Name: "host", HTTP: true, Type: stringType, Description: "host name from the request line, the \"Host\" request header field, or the server name", grammar: grammarHost},
+	{Name: "hostname", HTTP: true, Stream: true, Type: stringType, Description: "host name", grammar: grammarHost},
+	{Name: "http_", Prefix: true, HTTP: true, Type: stringType, Description: "request header field"},
+	{Name: "http_host", HTTP: true, Type: stringType, Description: "\"Host\" request header field", grammar: grammarHost},
+	{Name: "https", HTTP: true, Type: stringType, Description: "\"on\" if connection operates in SSL mode", grammar: fixedOf("on")},
+	{Name: "is_args", HTTP: true, Type: stringType, Description: "\"?\" if a request line has arguments", grammar: fixedOf("?")},
+	{Name: "msec", HTTP: true, Stream: true, Type: timeType, Description: "time in seconds with a milliseconds resolution", grammar: grammarSeconds},
+	{Name: "nginx_version", HTTP: true, Stream: true, Type: stringType, Description: "nginx version"},
+	{Name: "pid", HTTP: true, Stream: true, Type: intType, Description: "PID of the worker process", grammar: grammarNumber},
+	{Name: "pipe", HTTP: true, Type: stringType, Description: "\"p\" if request was pipelined, \".\" otherwise", grammar: grammarFlag},
+	{Name: "protocol", Stream: true, Type: stringType, Description: "protocol used to communicate with the client", grammar: fixedOf("TCP", "UDP")},
+	{Name: "proxy_protocol_addr", HTTP: true, Stream: true, Type: stringType, Description: "client address from the PROXY protocol header", grammar: grammarAddr},
+	{Name: "proxy_protocol_port", HTTP: true, Stream: true, Type: intType, Description: "client port from the PROXY protocol header", grammar: grammarNumber},
+	{Name: "proxy_protocol_server_addr", HTTP: true, Stream: true, Type: stringType, Description: "server address from the PROXY protocol header", grammar: grammarAddr},
+	{Name: "proxy_protocol_server_port", HTTP: true, Stream: true, Type: intType, Description: "server port from the PROXY protocol header", grammar: grammarNumber},
+	{Name: "query_string", HTTP: true, Type: stringType, Description: "same as $args"},
+	{Name: "realip_remote_addr", HTTP: true, Stream: true, Type: stringType, Description: "original client address", grammar: grammarAddr},
+	{Name: "realip_remote_port", HTTP: true, Stream: true, Type: intType, Description: "original client port", grammar: grammarNumber},
+	{Name: "realpath_root", HTTP: true, Type: stringType, Description: "absolute pathname of the root or alias directive's value"},
+	{Name: "remote_addr", HTTP: true, Stream: true, Type: stringType, Description: "client address", grammar: grammarAddr},
+	{Name: "remote_port", HTTP: true, Stream: true, Type: intType, Description: "client port", grammar: grammarNumber},
+	{Name: "remote_user", HTTP: true, Type: stringType, Description: "user name supplied with the Basic authentication"},
+	{Name: "request", HTTP: true, Type: stringType, Description: "full original request line"},
+	{Name: "request_body", HTTP: true, Type: stringType, Description: "request body"},
+	{Name: "request_completion", HTTP: true, Type: stringType, Description: "\"OK\" if a request has completed", grammar: fixedOf("OK")},
+	{Name: "request_filename", HTTP: true, Type: stringType, Description: "file path for the current request"},
+	{Name: "request_id", HTTP: true, Type: stringType, Description: "unique request identifier of 16 random bytes, in hexadecimal", grammar: runOf(isHex)},
+	{Name: "request_length", HTTP: true, Type: int64Type, Description: "request length, including request line, header, and body", grammar: grammarNumber},
+	{Name: "request_method", HTTP: true, Type: stringType, Description: "request method", grammar: grammarMethod},
+	{Name: "request_time", HTTP: true, Type: float64Type, Description: "request processing time in seconds with a milliseconds resolution", grammar: grammarSeconds},
+	{Name: "request_uri", HTTP: true, Type: stringType, Description: "full original request URI, with arguments"},
+	{Name: "scheme", HTTP: true, Type: stringType, Description: "request scheme, \"http\" or \"https\"", grammar: grammarScheme},
+	{Name: "sent_http_", Prefix: true, HTTP: true, Type: stringType, Description: "response header field"},
+	{Name: "sent_trailer_", Prefix: true, HTTP: true, Type: stringType, Description: "response trailer field"},
+	{Name: "server_addr", HTTP: true, Stream: true, Type: stringType, Description: "address of the server which accepted a request", grammar: grammarAddr},
+	{Name: "server_name", HTTP: true, Type: stringType, Description: "name of the server which accepted a request", grammar: grammarHost},
+	{Name: "server_port", HTTP: true, Stream: true, Type: intType, Description: "port of the server which accepted a request", grammar: grammarNumber},
+	{Name: "server_protocol", HTTP: true, Type: stringType, Description: "request protocol, such as \"HTTP/1.1\"", grammar: grammarProtocol},
+	{Name: "session_time", Stream: true, Type: float64Type, Description: "session duration in seconds with a milliseconds resolution", grammar: grammarSeconds},
+	{Name: "ssl_cipher", HTTP: true, Stream: true, Type: stringType, Description: "name of the cipher used for an established SSL connection"},
+	{Name: "ssl_client_verify", HTTP: true, Stream: true, Type: stringType, Description: "result of client certificate verification"},
+	{Name: "ssl_protocol", HTTP: true, Stream: true, Type: stringType, Description: "protocol of an established SSL connection", grammar: grammarSSLProtocol},
+	{Name: "ssl_server_name", HTTP: true, Stream: true, Type: stringType, Description: "server name requested through SNI", grammar: grammarHost},
+	{Name: "ssl_session_id", HTTP: true, Stream: true, Type: stringType, Description: "session identifier of an established SSL connection", grammar: runOf(isHex)},
+	{Name: "ssl_session_reused", HTTP: true, Stream: true, Type: stringType, Description: "\"r\" if an SSL session was reused, \".\" otherwise", grammar: grammarFlag},
+	{Name: "status", HTTP: true, Stream: true, Type: intType, Description: "response status, or session status in the stream module", grammar: grammarStatus},
+	{Name: "tcpinfo_rtt", HTTP: true, Type: intType, Description: "TCP_INFO round-trip time", grammar: grammarNumber},
+	{Name: "tcpinfo_rttvar", HTTP: true, Type: intType, Description: "TCP_INFO round-trip time variance", grammar: grammarNumber},
+	{Name: "tcpinfo_snd_cwnd", HTTP: true, Type: intType, Description: "TCP_INFO send congestion window", grammar: grammarNumber},
+	{Name: "tcpinfo_rcv_space", HTTP: true, Type: intType, Description: "TCP_INFO receive space", grammar: grammarNumber},
+	{Name: "time_iso8601", HTTP: true, Stream: true, Type: timeType, Description: "local time in the ISO 8601 standard format", grammar: grammarTimeISO8601},
+	{Name: "time_local", HTTP: true, Stream: true, Type: timeType, Description: "local time in the Common Log Format", grammar: grammarTimeLocal},
+	{Name: "upstream_", Prefix: true, HTTP: true, Stream: true, Type: stringsType, Description: "upstream variable", list: true},
+	{Name: "upstream_addr", HTTP: true, Stream: true, Type: stringsType, Description: "addresses of the upstream servers", list: true},
+	{Name: "upstream_bytes_received", HTTP: true, Stream: true, Type: int64sType, Description: "numbers of bytes received from the upstream servers", grammar: grammarNumber, list: true},
+	{Name: "upstream_bytes_sent", HTTP: true, Stream: true, Type: int64sType, Description: "numbers of bytes sent to the upstream servers", grammar: grammarNumber, list: true},
+	{Name: "upstream_cache_status", HTTP: true, Type: stringType, Description: "status of accessing a response cache", grammar: runOf(isUpper)},
+	{Name: "upstream_connect_time", HTTP: true, Stream: true, Type: float64sType, Description: "times spent on establishing connections with the upstream servers", grammar: grammarSeconds, list: true},
+	{Name: "upstream_cookie_", Prefix: true, HTTP: true, Type: stringsType, Description: "cookie sent by the upstream servers", list: true},
+	{Name: "upstream_first_byte_time", Stream: true, Type: float64sType, Description: "times to receive the first byte of data from the upstream servers", grammar: grammarSeconds, list: true},
+	{Name: "upstream_header_time", HTTP: true, Type: float64sType, Description: "times spent on receiving the response headers from the upstream servers", grammar: grammarSeconds, list: true},
+	{Name: "upstream_http_", Prefix: true, HTTP: true, Type: stringsType, Description: "response header field of the upstream servers", list: true},
+	{Name: "upstream_queue_time", HTTP: true, Type: float64sType, Description: "times the requests spent in the upstream queue", grammar: grammarSeconds, list: true},
+	{Name: "upstream_response_length", HTTP: true, Type: int64sType, Description: "lengths of the responses of the upstream servers", grammar: grammarNumber, list: true},
+	{Name: "upstream_response_time", HTTP: true, Type: float64sType, Description: "times spent on receiving the responses from the upstream servers", grammar: grammarSeconds, list: true},
+	{Name: "upstream_session_time", Stream: true, Type: float64sType, Description: "session durations with the upstream servers", grammar: grammarSeconds, list: true},
+	{Name: "upstream_status", HTTP: true, Type: intsType, Description: "status codes of the responses of the upstream servers", grammar: grammarStatus, list: true},
+	{Name: "upstream_trailer_", Prefix: true, HTTP: true, Type: stringsType, Description: "trailer field of the upstream servers", list: true},
+	{Name: "uri", HTTP: true, Type: stringType, Description: "current URI in request, normalized"},
+}
+
+var catalogByName = func() map[string]*Variable {
+	m := make(map[string]*Variable, len(catalog))
+	for _, v := range catalog {
+		m[v.Name] = v
+	}
+	return m
+}()
+
+// LookupVariable returns the catalog entry of the variable name, or of the
+// longest prefix of name such as "http_", or nil if name is unknown.
+func LookupVariable(name string) *Variable {
+	if v, ok := catalogByName[name]; ok && !v.Prefix {
+		return v
+	}
+	var found *Variable
+	for _, v := range catalog {
+		if v.Prefix && strings.HasPrefix(name, v.Name) && len(name) > len(v.Name) {
+			if found == nil || len(v.Name) > len(found.Name) {
+				found = v
+			}
+		}
+	}
+	return found
+}
+
+// Variables returns the catalog of known variables, sorted by name.
+func Variables() []Variable {
+	vars := make([]Variable, len(catalog))
+	for i, v := range catalog {
+		vars[i] = *v
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+// A FormatVariable describes a variable of a log format.
+type FormatVariable struct {
+	Name  string
+	Op    int  // index of the variable in the log format
+	Known bool // Variable comes from the catalog
+	// Variable is the catalog entry of the variable, or a string variable
+	// that accepts anything if the variable is unknown.
+	Variable Variable
+}
+
+// Describe returns the variables of the log format, in order.
+func (ngx *NGX) Describe() []FormatVariable {
+	var vars []FormatVariable
+	for i, op := range ngx.ops {
+		if op.Type != ngxVariable {
+			continue
+		}
+		name := string(op.Extra)
+		fv := FormatVariable{Name: name, Op: i}
+		if v := op.Var; v != nil {
+			fv.Known, fv.Variable = true, *v
+		} else {
+			fv.Variable = Variable{Name: name, HTTP: true, Stream: true, Type: stringType}
+		}
+		vars = append(vars, fv)
+	}
+	return vars
+}
+
+// Validate checks line against the log format, and every value against the
+// catalog entry of its variable. Invalid values are reported as FieldErrors
+// wrapping ErrInvalidValue.
+func (ngx *NGX) Validate(line string) error {
+	return ngx.validate(NewStringReader(line).Bytes(), false)
+}
+
+func (ngx *NGX) validate(data []byte, whole bool) error {
+	st := acquireState(len(ngx.ops))
+	defer releaseState(st)
+	spans := st.spans
+	if err := ngx.splitWith(data, spans, acceptValue); err != nil {
+		return err
+	}
+	if p := ngx.end(spans); whole && p < len(data) {
+		return &SyntaxError{Msg: "got unexpected data after the end of the log format", Op: len(ngx.ops) - 1, Offset: p, Snippet: snippet(data, p)}
+	}
+	var errs FieldErrors
+	for i, op := range ngx.ops {
+		if op.Type != ngxVariable {
+			continue
+		}
+		v := op.Var
+		if v == nil {
+			continue
+		}
+		raw, err := ngx.esc.Unescape(data[spans[i].start:spans[i].end])
+		if err == nil && string(raw) != ngx.esc.Nil() {
+			err = v.validate(raw)
+		}
+		if err != nil {
+			errs = append(errs, fieldError(data, spans[i], i, op, "", err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func acceptValue(i int, raw []byte) bool {
+	return true
+}
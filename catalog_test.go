@@ -0,0 +1,146 @@
+package ngx
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLookupVariable(t *testing.T) {
+	for _, tc := range []struct {
+		Name     string
+		Expected string
+	}{
+		{"status", "status"},
+		{"http_host", "http_host"},
+		{"http_user_agent", "http_"},
+		{"upstream_http_server", "upstream_http_"},
+		{"upstream_x", "upstream_"},
+		{"http_", ""},
+		{"my_variable", ""},
+	} {
+		name := ""
+		if v := LookupVariable(tc.Name); v != nil {
+			name = v.Name
+		}
+		if name != tc.Expected {
+			t.Fatalf("unexpected catalog entry for %q: expecting %q, got %q", tc.Name, tc.Expected, name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		Name  string
+		Value string
+		Valid bool
+	}{
+		{"status", "200", true},
+		{"status", "-", true},
+		{"status", "20", false},
+		{"request_time", "0.003", true},
+		{"request_time", "3ms", false},
+		{"remote_addr", "2001:db8::1", true},
+		{"remote_addr", "example.com", false},
+		{"ssl_protocol", "TLSv1.3", true},
+		{"time_local", "10/Oct/2020:13:55:36 +0000", true},
+		{"time_local", "10/Oct/2020:06:55:36 -0700", true},
+		{"time_local", "10/Oct/2020:06:55:36 0700", false},
+		{"time_iso8601", "2020-10-10T08:55:36-05:00", true},
+		{"time_iso8601", "2020-10-10T08:55:36*05:00", false},
+		{"upstream_status", "502, 504 : 200", true},
+		{"upstream_status", "502, - : 200", true},
+		{"upstream_status", "502; 200", false},
+		{"http_user_agent", "anything", true},
+	} {
+		err := LookupVariable(tc.Name).Validate(tc.Value)
+		if (err == nil) != tc.Valid {
+			t.Fatalf("unexpected validation of %q for $%s: %v", tc.Value, tc.Name, err)
+		}
+	}
+
+	ngx, err := Compile(`$remote_addr [$status] $request_time $my_variable`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ngx.Validate(`127.0.0.1 [200] 0.003 anything`); err != nil {
+		t.Fatalf("unexpected error on a valid line: %v", err)
+	}
+	err = ngx.Validate(`127.0.0.1 [2000] 3ms anything`)
+	var errs FieldErrors
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[0].Variable != "status" || !errors.Is(errs[1], ErrInvalidValue) {
+		t.Fatalf("expecting errors on status and request_time, got %v", err)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	ngx, err := Compile(`$remote_addr [$time_local] $http_x_forwarded_for $my_variable`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := ngx.Describe()
+	if len(vars) != 4 {
+		t.Fatalf("expecting 4 variables, got %d", len(vars))
+	}
+	for i, expected := range []struct {
+		Name  string
+		Op    int
+		Known bool
+		Type  reflect.Type
+	}{
+		{"remote_addr", 0, true, stringType},
+		{"time_local", 2, true, timeType},
+		{"http_x_forwarded_for", 4, true, stringType},
+		{"my_variable", 6, false, stringType},
+	} {
+		v := vars[i]
+		if v.Name != expected.Name || v.Op != expected.Op || v.Known != expected.Known || v.Variable.Type != expected.Type {
+			t.Fatalf("unexpected description of $%s: %+v", expected.Name, v)
+		}
+	}
+}
+
+func TestInterfaceMapCodec(t *testing.T) {
+	ngx, err := Compile(`$remote_addr [$time_iso8601] $status $body_bytes_sent $request_time "$http_referer" $upstream_status`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := `127.0.0.1 [2020-01-02T07:04:05-01:00] 200 512 0.003 "-" 502, 504`
+	got := map[string]interface{}{}
+	if err := ngx.UnmarshalFromString(data, &got); err != nil {
+		t.Fatalf("failed to UnmarshalFromString() data %q: %v", data, err)
+	}
+	expected := map[string]interface{}{
+		"remote_addr":     "127.0.0.1",
+		"time_iso8601":    iso8601,
+		"status":          200,
+		"body_bytes_sent": int64(512),
+		"request_time":    0.003,
+		"http_referer":    nil,
+		"upstream_status": []int{502, 504},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("corrupted data in UnmarshalFromString(): expecting %v, got %v", expected, got)
+	}
+
+	marshaled, err := ngx.MarshalToString(got)
+	if err != nil {
+		t.Fatalf("failed to MarshalToString() data %v: %v", got, err)
+	}
+	if marshaled != data {
+		t.Fatalf("corrupted data in MarshalToString(): expecting %q, got %q", data, marshaled)
+	}
+
+	// groups do not fit the []int of the catalog
+	data = `127.0.0.1 [2020-01-02T07:04:05-01:00] 200 512 0.003 "-" 502, 504 : 200`
+	got = map[string]interface{}{}
+	if err := ngx.UnmarshalFromString(data, &got); err != nil {
+		t.Fatalf("failed to UnmarshalFromString() data %q: %v", data, err)
+	}
+	if got["upstream_status"] != "502, 504 : 200" {
+		t.Fatalf("expecting the upstream groups kept as a string, got %#v", got["upstream_status"])
+	}
+	if marshaled, err := ngx.MarshalToString(got); err != nil || marshaled != data {
+		t.Fatalf("corrupted data in MarshalToString(): expecting %q, got %q (%v)", data, marshaled, err)
+	}
+}
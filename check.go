@@ -0,0 +1,105 @@
+package ngx
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/modern-go/reflect2"
+)
+
+// checkSamples are values of the types of the catalog, encoded by the codec
+// of a variable to get a value it may have.
+var checkSamples = map[reflect.Type]interface{}{
+	intType:      1,
+	int64Type:    int64(1),
+	float64Type:  0.5,
+	intsType:     []int{1, 2},
+	int64sType:   []int64{1, 2},
+	float64sType: []float64{0.5, 1},
+	timeType:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+}
+
+// Check reports how the struct v, or the struct it points to, binds to the
+// log format, as BindingErrors: the fields no variable binds, the variables
+// no field binds, and the fields that cannot hold the values of their
+// variable, given its type in the catalog. Fields tagged "_", with a default
+// or catching other variables are not reported. It is meant for tests and
+// startup checks, a struct that does not bind exactly still decodes.
+func (ngx *NGX) Check(v interface{}) error {
+	typ := reflect2.TypeOf(v)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.(*reflect2.UnsafePtrType).Elem()
+	}
+	st, ok := typ.(*reflect2.UnsafeStructType)
+	if !ok {
+		return fmt.Errorf("cannot check %T, which is not a struct", v)
+	}
+	codec, err := codecOfStruct(ngx, st)
+	if err != nil {
+		return err
+	}
+	d := codec.(*structCodec)
+
+	var errs BindingErrors
+	for _, field := range d.unbound {
+		errs = append(errs, &BindingError{Field: field, Msg: "no variable of the log format binds the field"})
+	}
+	for i, op := range d.ops {
+		name := string(op.Extra)
+		switch {
+		case op.Type == ngxVariable && name != "_":
+			errs = append(errs, &BindingError{Op: i, Variable: name, Msg: "no field binds the variable"})
+		case op.Type == ngxBind:
+			if msg := ngx.checkType(name, &op); msg != "" {
+				errs = append(errs, &BindingError{Op: i, Field: op.Field, Variable: name, Msg: msg})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkType returns why the field of op cannot hold the values of its
+// variable, or "". Values of numbers and times must decode into the field,
+// and those of text variables must not go to numbers.
+func (ngx *NGX) checkType(name string, op *structOp) string {
+	v := LookupVariable(name)
+	if v == nil {
+		return ""
+	}
+	if sample, ok := checkSamples[v.Type]; ok {
+		codec, err := codecOfVar(ngx, name, reflect2.Type2(v.Type))
+		if err != nil {
+			return ""
+		}
+		w := AcquireWriter()
+		defer ReleaseWriter(w)
+		if err := codec.Encode(reflect2.PtrOf(sample), w); err != nil {
+			return ""
+		}
+		if err := op.Codec.Decode(op.Typ.UnsafeNew(), NewBytesReader(w.Bytes())); err != nil {
+			return fmt.Sprintf("values such as %q do not decode into %s: %v", w.String(), op.Typ, err)
+		}
+		return ""
+	}
+
+	typ := op.Typ
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.(*reflect2.UnsafePtrType).Elem()
+	}
+	if isOptional(typ) {
+		typ = typ.(*reflect2.UnsafeStructType).Field(0).Type()
+	}
+	if codecOfHook(ngx, typ, ngx.esc, true) != nil {
+		return ""
+	}
+	switch typ.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return fmt.Sprintf("values are text, which does not fit in %s", op.Typ)
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package ngx
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type checked struct {
+	Addr    string    `ngx:"remote_addr"`
+	Status  string    `ngx:"status"`
+	Time    int       `ngx:"request_time"`
+	User    int       `ngx:"remote_user"`
+	Local   time.Time `ngx:"time_local"`
+	Typo    string    `ngx:"staus"`
+	Skipped string    `ngx:"_"`
+	Host    string    `ngx:"host,default=localhost"`
+}
+
+type checkedOK struct {
+	Addr   string            `ngx:"remote_addr"`
+	Status *int              `ngx:"status"`
+	Time   time.Duration     `ngx:"request_time"`
+	Local  string            `ngx:"time_local"`
+	Rest   map[string]string `ngx:",rest"`
+}
+
+func TestCheck(t *testing.T) {
+	ngx, err := Compile(`$remote_addr [$time_local] $status $request_time $remote_user $body_bytes_sent`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ngx.Check(&checked{})
+	errs, ok := err.(BindingErrors)
+	if !ok {
+		t.Fatalf("expecting BindingErrors, got %v", err)
+	}
+	var got [][2]string
+	for _, e := range errs {
+		got = append(got, [2]string{e.Field, e.Variable})
+	}
+	expected := [][2]string{
+		{"Typo", ""},
+		{"Time", "request_time"},
+		{"User", "remote_user"},
+		{"", "body_bytes_sent"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expecting %v, got %v: %v", expected, got, err)
+	}
+
+	if err := ngx.Check(checkedOK{}); err != nil {
+		t.Fatalf("expecting no error, got %v", err)
+	}
+	if err := ngx.Check(map[string]string{}); err == nil {
+		t.Fatalf("expecting an error checking a map")
+	}
+}
@@ -0,0 +1,145 @@
+// Command ngx inspects nginx log formats.
+//
+// Usage:
+//
+//	ngx lint [-conf nginx.conf] [format or name ...]
+//	ngx infer [-n lines] [file ...]
+//
+// lint reports the variables of log formats that may not decode reliably,
+// along with a suggested fix. Formats are given as arguments, in the syntax
+// accepted by ngx.Compile, or loaded from a nginx configuration file, in which
+// case arguments select formats by name. It exits with status 1 if any
+// warning is reported.
+//
+// infer guesses the log format of the first lines of log files, or of the
+// standard input, and prints it in the syntax accepted by ngx.Compile.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/tr3ee/ngx-go"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: ngx lint [-conf nginx.conf] [format or name ...]")
+	fmt.Fprintln(os.Stderr, "       ngx infer [-n lines] [file ...]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "lint":
+		os.Exit(lint(os.Args[2:]))
+	case "infer":
+		os.Exit(infer(os.Args[2:]))
+	default:
+		usage()
+	}
+}
+
+func lint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	conf := flags.String("conf", "", "load log formats from a nginx configuration `file`")
+	flags.Parse(args)
+
+	formats := make(map[string]*ngx.NGX)
+	if *conf != "" {
+		config, err := ngx.LoadConfig(*conf)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		formats = config.Formats()
+		if flags.NArg() > 0 {
+			selected := make(map[string]*ngx.NGX)
+			for _, name := range flags.Args() {
+				f, ok := formats[name]
+				if !ok {
+					fmt.Fprintf(os.Stderr, "%s: unknown log format %q\n", *conf, name)
+					return 2
+				}
+				selected[name] = f
+			}
+			formats = selected
+		}
+	} else {
+		if flags.NArg() == 0 {
+			usage()
+		}
+		for _, src := range flags.Args() {
+			f, err := ngx.Compile(src)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%q: %v\n", src, err)
+				return 2
+			}
+			formats[src] = f
+		}
+	}
+
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	status := 0
+	for _, name := range names {
+		for _, w := range formats[name].Lint() {
+			fmt.Printf("%s: %s\n", name, w)
+			status = 1
+		}
+	}
+	return status
+}
+
+func infer(args []string) int {
+	flags := flag.NewFlagSet("infer", flag.ExitOnError)
+	n := flags.Int("n", 1000, "read at most `lines` lines of each file")
+	flags.Parse(args)
+
+	var samples []string
+	read := func(r io.Reader) error {
+		sc := bufio.NewScanner(r)
+		sc.Buffer(nil, 1<<20)
+		for i := 0; i < *n && sc.Scan(); i++ {
+			samples = append(samples, sc.Text())
+		}
+		return sc.Err()
+	}
+	if flags.NArg() == 0 {
+		if err := read(os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	for _, name := range flags.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		err = read(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			return 2
+		}
+	}
+
+	logfmt, err := ngx.InferLogFormat(samples)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(logfmt)
+	return 0
+}
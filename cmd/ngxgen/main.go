@@ -0,0 +1,490 @@
+// Command ngxgen generates the UnmarshalNGX and MarshalNGX methods of a struct
+// type for a single log format, decoding and encoding its lines without
+// reflection.
+//
+// Usage:
+//
+//	//go:generate ngxgen -type Access -const AccessFormat
+//
+// ngxgen reads the Go files of the package in the current directory, where
+// the string constant given with -const holds the log format. The format may
+// also be given with -format, in which case go generate needs every $ written
+// as $DOLLAR. The methods are written to access_ngx.go, or to the file given
+// with -output.
+//
+// Fields are bound to variables the way ngx binds them at run time. Fields of
+// type string, []byte, bool, and of the int, uint and float kinds other than
+// byte, are decoded and encoded inline. The others go through the codec ngx
+// would use for them.
+//
+// The generated NGXFormat method tells ngx which log format the methods are
+// for, so that an NGX compiled from another format keeps decoding the type
+// with reflection. Formats with adjacent variables are not supported.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/tr3ee/ngx-go"
+)
+
+func main() {
+	typeName := flag.String("type", "", "name of the struct `type`")
+	logfmt := flag.String("format", "", "log `format`, in the syntax accepted by ngx.Compile")
+	constName := flag.String("const", "", "name of the string `constant` holding the log format")
+	output := flag.String("output", "", "output `file`, <type>_ngx.go if empty")
+	flag.Parse()
+	if *typeName == "" || (*logfmt == "") == (*constName == "") || flag.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: ngxgen -type T (-const C | -format log_format) [-output file]")
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = strings.ToLower(*typeName) + "_ngx.go"
+	}
+
+	src, err := generate(".", os.Getenv("GOPACKAGE"), *typeName, *constName, *logfmt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ngxgen: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "ngxgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// maxSnippetSize is the length ngx truncates the Snippet of errors to.
+const maxSnippetSize = 32
+
+// field is a struct field bound to a variable.
+type field struct {
+	name string // name of the struct field
+	typ  string // type of the struct field, as written in the source
+}
+
+// generate returns the source of the methods of the struct typeName, declared
+// in package pkgName in dir, or in any package if pkgName is empty, for the
+// log format held by the constant constName if not empty, or else logfmt.
+func generate(dir, pkgName, typeName, constName, logfmt string) ([]byte, error) {
+	pkg, st, err := findStruct(dir, pkgName, typeName)
+	if err != nil {
+		return nil, err
+	}
+	if constName != "" {
+		if logfmt, err = constString(pkg, constName); err != nil {
+			return nil, err
+		}
+	}
+	compiled, err := ngx.Compile(logfmt)
+	if err != nil {
+		return nil, err
+	}
+	parts := compiled.Parts()
+	for i, part := range parts {
+		if strings.IndexByte(part.Variable, '.') >= 0 {
+			return nil, fmt.Errorf("$%s is a dotted variable, which is not supported", part.Variable)
+		}
+		if i+1 < len(parts) && part.Variable != "" && parts[i+1].Variable != "" {
+			return nil, fmt.Errorf("$%s and $%s are adjacent, which is not supported", part.Variable, parts[i+1].Variable)
+		}
+	}
+
+	bound := make(map[int]field) // by index of the variable in parts
+	supported := compiled.Supported()
+	for _, f := range st.Fields.List {
+		typ := types.ExprString(f.Type)
+		names := make([]string, 0, len(f.Names))
+		for _, name := range f.Names {
+			names = append(names, name.Name)
+		}
+		tag := ""
+		if f.Tag != nil {
+			s, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(s).Get("ngx")
+		}
+		if len(names) == 0 { // embedded
+			if tag == "" {
+				return nil, fmt.Errorf("embedded field %s has no ngx tag, which is not supported", typ)
+			}
+			names = append(names, strings.TrimPrefix(typ[strings.LastIndexByte(typ, '.')+1:], "*"))
+		}
+		if strings.IndexByte(tag, ',') >= 0 {
+			return nil, fmt.Errorf("ngx tag options of %s are not supported", strings.Join(names, ", "))
+		}
+		if strings.IndexByte(tag, '*') >= 0 {
+			return nil, fmt.Errorf("wildcard ngx tags of %s are not supported", strings.Join(names, ", "))
+		}
+		for _, name := range names {
+			if name == "_" || tag == "_" {
+				continue
+			}
+			if i, ok := bindField(supported, name, tag); ok {
+				bound[i] = field{name, typ}
+			}
+		}
+	}
+
+	g := &generator{
+		typ:   typeName,
+		esc:   compiled.Escaping(),
+		parts: parts,
+		bound: bound,
+		lib:   "ngx",
+	}
+	if lookup(pkg, "ngx") != nil {
+		g.lib = "ngxgo"
+	}
+	return g.generate(pkg.Name, logfmt)
+}
+
+// bindField returns the index of the variable bound to the field name, like
+// the reflection codecs do: the first of the names of tag separated by '|'
+// found in the log format, or else the variable called name.
+func bindField(supported map[string]int, name, tag string) (int, bool) {
+	if tag != "" {
+		for _, variable := range strings.Split(tag, "|") {
+			if i, ok := supported[variable]; ok {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+	i, ok := supported[name]
+	return i, ok
+}
+
+// findStruct returns the package of dir declaring the struct typeName.
+func findStruct(dir, pkgName, typeName string) (*ast.Package, *ast.StructType, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, nil, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, pkg := range pkgs {
+		if pkgName != "" && name != pkgName {
+			continue
+		}
+		spec, _ := lookup(pkg, typeName).(*ast.TypeSpec)
+		if spec == nil {
+			continue
+		}
+		st, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is not a struct type", typeName)
+		}
+		return pkg, st, nil
+	}
+	abs, _ := filepath.Abs(dir)
+	return nil, nil, fmt.Errorf("type %s not found in %s", typeName, abs)
+}
+
+// lookup returns the spec of the package level declaration of name in pkg,
+// or nil.
+func lookup(pkg *ast.Package, name string) ast.Spec {
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if spec.Name.Name == name {
+						return spec
+					}
+				case *ast.ValueSpec:
+					for _, ident := range spec.Names {
+						if ident.Name == name {
+							return spec
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// constString returns the value of the string constant name of pkg.
+func constString(pkg *ast.Package, name string) (string, error) {
+	spec, _ := lookup(pkg, name).(*ast.ValueSpec)
+	if spec != nil {
+		for i, ident := range spec.Names {
+			if ident.Name == name && i < len(spec.Values) {
+				if s, ok := evalString(spec.Values[i]); ok {
+					return s, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("%s is not a string constant", name)
+}
+
+// evalString evaluates string literals and their concatenations.
+func evalString(expr ast.Expr) (string, bool) {
+	switch expr := expr.(type) {
+	case *ast.BasicLit:
+		if expr.Kind == token.STRING {
+			s, err := strconv.Unquote(expr.Value)
+			return s, err == nil
+		}
+	case *ast.ParenExpr:
+		return evalString(expr.X)
+	case *ast.BinaryExpr:
+		if expr.Op == token.ADD {
+			x, ok := evalString(expr.X)
+			y, ok2 := evalString(expr.Y)
+			return x + y, ok && ok2
+		}
+	}
+	return "", false
+}
+
+type generator struct {
+	typ   string
+	esc   ngx.Esc
+	parts []ngx.FormatPart
+	bound map[int]field
+	lib   string // name the ngx package is imported as
+
+	w       *bytes.Buffer
+	imports map[string]bool
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(g.w, format, args...)
+}
+
+func (g *generator) generate(pkgName, logfmt string) ([]byte, error) {
+	g.w = new(bytes.Buffer)
+	g.imports = make(map[string]bool)
+	literals := g.name("Literals")
+	g.printf("const %s = %q\n\n", g.name("Format"), logfmt)
+	g.printf("var %s = [...][]byte{\n", literals)
+	lits := make(map[int]string) // expression of the literal parts[i]
+	for i, part := range g.parts {
+		if part.Variable == "" {
+			lits[i] = fmt.Sprintf("%s[%d]", literals, len(lits))
+			g.printf("[]byte(%q),\n", part.Literal)
+		}
+	}
+	g.printf("}\n\n")
+
+	g.printf("// NGXFormat returns the log format UnmarshalNGX and MarshalNGX are generated for.\n")
+	g.printf("func (*%s) NGXFormat() string {\nreturn %s\n}\n\n", g.typ, g.name("Format"))
+	g.unmarshal(lits)
+	g.marshal(lits)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by ngxgen -type %s. DO NOT EDIT.\n\npackage %s\n\nimport (\n", g.typ, pkgName)
+	for _, path := range []string{"bytes", "fmt", "strconv"} {
+		if g.imports[path] {
+			fmt.Fprintf(&out, "%q\n", path)
+		}
+	}
+	if g.lib != "ngx" {
+		fmt.Fprintf(&out, "\n%s %q\n)\n\n", g.lib, "github.com/tr3ee/ngx-go")
+	} else {
+		fmt.Fprintf(&out, "\n%q\n)\n\n", "github.com/tr3ee/ngx-go")
+	}
+	out.Write(g.w.Bytes())
+	return format.Source(out.Bytes())
+}
+
+// name returns the name of a package level declaration for the type.
+func (g *generator) name(suffix string) string {
+	return "ngx" + strings.ToUpper(g.typ[:1]) + g.typ[1:] + suffix
+}
+
+func (g *generator) escExpr() string {
+	switch g.esc {
+	case ngx.EscJson:
+		return g.lib + ".EscJson"
+	case ngx.EscNone:
+		return g.lib + ".EscNone"
+	}
+	return g.lib + ".EscDefault"
+}
+
+var intBits = map[string]int{
+	"int": 0, "int8": 8, "int16": 16, "int32": 32, "int64": 64,
+	"uint": 0, "uint16": 16, "uint32": 32, "uint64": 64,
+}
+
+// unmarshal prints UnmarshalNGX, which splits the line before decoding any
+// field, so that v is left unchanged if the line does not match.
+func (g *generator) unmarshal(lits map[int]string) {
+	w := g.w
+	g.w = new(bytes.Buffer)
+	vars := map[string]bool{}
+	slots := make(map[int]int) // index in spans of the start of parts[i]
+	for i, part := range g.parts {
+		if part.Variable == "" {
+			if i > 0 && g.parts[i-1].Variable != "" {
+				continue // matched along with the variable before it
+			}
+			g.imports["bytes"] = true
+			g.printf("if !bytes.HasPrefix(data[p:], %s) {\n", lits[i])
+			g.printf("got := data[p:]\nif len(got) > %d {\ngot = got[:%d]\n}\n", len(part.Literal), len(part.Literal))
+			g.printf("return &%s.LiteralMismatchError{Op: %d, Offset: p, Expected: %q, Got: string(got)}\n}\n", g.lib, i, part.Literal)
+			g.printf("p += %d\n", len(part.Literal))
+			continue
+		}
+
+		_, bound := g.bound[i]
+		if bound {
+			slots[i] = 2 * len(slots)
+		}
+		if i+1 >= len(g.parts) {
+			if bound {
+				g.printf("spans[%d], spans[%d] = p, len(data)\n", slots[i], slots[i]+1)
+			}
+			continue
+		}
+		next := g.parts[i+1].Literal
+		vars["n"] = true
+		g.printf("if n = %s.IndexValue(%s, %q, data[p:], %s); n < 0 {\n", g.lib, g.escExpr(), part.Variable, lits[i+1])
+		g.printf("return &%s.SyntaxError{Msg: %q, Op: %d, Variable: %q, Offset: p}\n}\n", g.lib,
+			fmt.Sprintf("got unexpected EOF: expecting %q after $%s", next, part.Variable), i, part.Variable)
+		if bound {
+			g.printf("spans[%d], spans[%d] = p, p+n\n", slots[i], slots[i]+1)
+		}
+		g.printf("p += n + %d\n", len(next))
+	}
+
+	for i, part := range g.parts {
+		f, ok := g.bound[i]
+		if !ok {
+			continue
+		}
+		span := fmt.Sprintf("data[spans[%d]:spans[%d]]", slots[i], slots[i]+1)
+		g.printf("\n// $%s\n", part.Variable)
+		g.printf("if raw, err = %s.Unescape(%s); err == nil {\n", g.escExpr(), span)
+		g.decode(f, part.Variable, vars)
+		g.printf("}\nif err != nil {\n")
+		g.printf("if raw = %s; len(raw) > %d {\nraw = raw[:%d]\n}\n", span, maxSnippetSize, maxSnippetSize)
+		g.printf("return &%s.FieldError{Op: %d, Variable: %q, Field: %q, Offset: spans[%d], Raw: string(%s), Snippet: string(raw), Err: err}\n}\n",
+			g.lib, i, part.Variable, f.name, slots[i], span)
+	}
+	body := g.w
+	g.w = w
+
+	g.printf("// UnmarshalNGX decodes a line of the log format into v.\n")
+	g.printf("func (v *%s) UnmarshalNGX(data []byte) error {\n", g.typ)
+	g.printf("var (\np int\n")
+	if len(slots) > 0 {
+		g.printf("spans [%d]int\nraw []byte\nerr error\n", 2*len(slots))
+	}
+	for _, v := range []struct{ name, decl string }{
+		{"n", "n int"},
+		{"i64", "i64 int64"},
+		{"u64", "u64 uint64"},
+		{"f64", "f64 float64"},
+	} {
+		if vars[v.name] {
+			g.printf("%s\n", v.decl)
+		}
+	}
+	g.printf(")\n")
+	g.w.Write(body.Bytes())
+	g.printf("return nil\n}\n\n")
+}
+
+// decode prints the statements decoding raw into the field f, setting err.
+func (g *generator) decode(f field, variable string, vars map[string]bool) {
+	dst := "v." + f.name
+	conv := func(v string) string {
+		if map[string]string{"i64": "int64", "u64": "uint64", "f64": "float64"}[v] == f.typ {
+			return v
+		}
+		return f.typ + "(" + v + ")"
+	}
+	switch f.typ {
+	case "string":
+		g.printf("%s = string(raw)\n", dst)
+	case "[]byte":
+		g.printf("%s = append([]byte(nil), raw...)\n", dst)
+	case "bool":
+		g.imports["bytes"] = true
+		g.printf("%s = bytes.EqualFold(raw, []byte(\"true\"))\n", dst)
+	case "int", "int8", "int16", "int32", "int64":
+		vars["i64"] = true
+		g.imports["strconv"] = true
+		g.printf("if len(raw) == 0 || string(raw) == %q {\n%s = 0\n", g.esc.Nil(), dst)
+		g.printf("} else if i64, err = strconv.ParseInt(string(raw), 10, %d); err == nil {\n%s = %s\n}\n", intBits[f.typ], dst, conv("i64"))
+	case "uint", "uint16", "uint32", "uint64":
+		vars["u64"] = true
+		g.imports["strconv"] = true
+		g.printf("if len(raw) == 0 || string(raw) == %q {\n%s = 0\n", g.esc.Nil(), dst)
+		g.printf("} else if u64, err = strconv.ParseUint(string(raw), 10, %d); err == nil {\n%s = %s\n}\n", intBits[f.typ], dst, conv("u64"))
+	case "float32", "float64":
+		vars["f64"] = true
+		g.printf("if len(raw) == 0 || string(raw) == %q {\n%s = 0\n", g.esc.Nil(), dst)
+		g.printf("} else if f64, err = %s.ParseFloat(raw, %s); err == nil {\n%s = %s\n}\n", g.lib, f.typ[5:], dst, conv("f64"))
+	default:
+		g.printf("err = %s.DecodeValue(%s, %q, raw, &%s)\n", g.lib, g.escExpr(), variable, dst)
+	}
+}
+
+func (g *generator) marshal(lits map[int]string) {
+	w := g.w
+	g.w = new(bytes.Buffer)
+	hasErr := false
+	for i, part := range g.parts {
+		if part.Variable == "" {
+			g.printf("buf = append(buf, %s...)\n", lits[i])
+			continue
+		}
+		f, ok := g.bound[i]
+		if !ok {
+			g.printf("buf = append(buf, %q...)\n", g.esc.Nil())
+			continue
+		}
+		src := "v." + f.name
+		switch f.typ {
+		case "string":
+			g.printf("buf = append(buf, %s.Escape([]byte(%s))...)\n", g.escExpr(), src)
+		case "[]byte":
+			g.printf("buf = append(buf, %s.Escape(%s)...)\n", g.escExpr(), src)
+		case "bool":
+			g.imports["strconv"] = true
+			g.printf("buf = strconv.AppendBool(buf, %s)\n", src)
+		case "int", "int8", "int16", "int32", "int64":
+			g.imports["strconv"] = true
+			g.printf("buf = strconv.AppendInt(buf, int64(%s), 10)\n", src)
+		case "uint", "uint16", "uint32", "uint64":
+			g.imports["strconv"] = true
+			g.printf("buf = strconv.AppendUint(buf, uint64(%s), 10)\n", src)
+		default:
+			hasErr = true
+			g.imports["fmt"] = true
+			g.printf("if buf, err = %s.AppendValue(buf, %s, %q, &%s); err != nil {\n", g.lib, g.escExpr(), part.Variable, src)
+			g.printf("return nil, fmt.Errorf(\"field %%q %%v\", %q, err)\n}\n", part.Variable)
+		}
+	}
+	body := g.w
+	g.w = w
+
+	g.printf("// MarshalNGX encodes v as a line of the log format.\n")
+	g.printf("func (v *%s) MarshalNGX() ([]byte, error) {\n", g.typ)
+	if hasErr {
+		g.printf("var err error\n")
+	}
+	g.printf("buf := make([]byte, 0, 256)\n")
+	g.w.Write(body.Bytes())
+	g.printf("return buf, nil\n}\n")
+}
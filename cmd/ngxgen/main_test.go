@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	src, err := generate("../..", "ngx_test", "genAccess", "genFormat", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile("../../genaccess_ngx_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, expected) {
+		t.Fatalf("genaccess_ngx_test.go is out of date, run go generate")
+	}
+
+	for _, tc := range []struct {
+		Type, Const, Format string
+	}{
+		{"missing", "", "$status"},
+		{"genAccess", "", "$status$body_bytes_sent"},
+		{"genAccess", "", "$status $header.cookie"},
+		{"genAccess", "missing", ""},
+	} {
+		if _, err := generate("../..", "ngx_test", tc.Type, tc.Const, tc.Format); err == nil {
+			t.Fatalf("expecting an error generating %+v", tc)
+		}
+	}
+}
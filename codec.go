@@ -12,29 +12,89 @@ import (
 )
 
 func codecOf(ngx *NGX, typ reflect2.Type) (Codec, error) {
+	if codec := codecOfHook(ngx, typ, ngx.esc, true); codec != nil {
+		return codec, nil
+	}
+	return codecOfKind(ngx, typ)
+}
+
+// codecOfVar returns the codec of a value bound to the variable name. Unlike
+// codecOf, it knows about types whose text depends on the variable.
+func codecOfVar(ngx *NGX, name string, typ reflect2.Type) (Codec, error) {
+	if codec := registeredCodec(typ, ngx.esc); codec != nil {
+		return codec, nil
+	}
+	if typ.Kind() == reflect.Interface && typ.Type1().NumMethod() == 0 {
+		return codecOfAny(ngx, name)
+	}
+
+	switch typ.Type1() {
+	case timeType:
+		if name == "msec" {
+			return &msecCodec{ngx.esc}, nil
+		}
+		layout, ok := timeLayouts[name]
+		if !ok {
+			layout = timeLayouts["time_iso8601"]
+		}
+		return &timeCodec{ngx.esc, layout}, nil
+	case durationType:
+		return &durationCodec{ngx.esc}, nil
+	case requestLineType:
+		return &requestLineCodec{ngx.esc}, nil
+	}
+
+	if isUpstreamVar(name) && isUpstreamList(typ) {
+		return codecOfUpstream(ngx, name, typ.(*reflect2.UnsafeSliceType))
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		elem := typ.(*reflect2.UnsafePtrType).Elem()
+		codec, err := codecOfVar(ngx, name, elem)
+		if err != nil {
+			return nil, err
+		}
+		return &ptrCodec{ngx.esc.Nil(), codec, elem}, nil
+	}
+
+	if isOptional(typ) {
+		if codec := codecOfHook(ngx, typ, ngx.esc, true); codec != nil {
+			return codec, nil
+		}
+		return codecOfOptional(ngx, name, typ.(*reflect2.UnsafeStructType))
+	}
+
+	return codecOf(ngx, typ)
+}
+
+func codecOfKind(ngx *NGX, typ reflect2.Type) (Codec, error) {
 	switch typ.Kind() {
 	case reflect.Bool:
 		return &boolCodec{}, nil
 	case reflect.Int:
-		return &intCodec{}, nil
+		return &intCodec{ngx.esc}, nil
 	case reflect.Uint:
-		return &uintCodec{}, nil
+		return &uintCodec{ngx.esc}, nil
 	case reflect.Int8:
-		return &int8Codec{}, nil
+		return &int8Codec{ngx.esc}, nil
 	case reflect.Uint8:
 		return &byteCodec{}, nil
 	case reflect.Int16:
-		return &int16Codec{}, nil
+		return &int16Codec{ngx.esc}, nil
 	case reflect.Uint16:
-		return &uint16Codec{}, nil
+		return &uint16Codec{ngx.esc}, nil
 	case reflect.Int32:
-		return &int32Codec{}, nil
+		return &int32Codec{ngx.esc}, nil
 	case reflect.Uint32:
-		return &uint32Codec{}, nil
+		return &uint32Codec{ngx.esc}, nil
 	case reflect.Int64:
-		return &int64Codec{}, nil
+		return &int64Codec{ngx.esc}, nil
 	case reflect.Uint64:
-		return &uint64Codec{}, nil
+		return &uint64Codec{ngx.esc}, nil
+	case reflect.Float32:
+		return &float32Codec{ngx.esc}, nil
+	case reflect.Float64:
+		return &float64Codec{ngx.esc}, nil
 	case reflect.Slice:
 		if typ.(reflect2.SliceType).Elem().Kind() == reflect.Uint8 {
 			return &bytesCodec{ngx.esc}, nil
@@ -76,6 +136,7 @@ func (d *byteCodec) Decode(ptr unsafe.Pointer, text Reader) error {
 }
 
 type int8Codec struct {
+	esc Esc
 }
 
 func (d *int8Codec) Encode(ptr unsafe.Pointer, text Writer) error {
@@ -85,6 +146,10 @@ func (d *int8Codec) Encode(ptr unsafe.Pointer, text Writer) error {
 }
 
 func (d *int8Codec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*int8)(ptr) = 0
+		return nil
+	}
 	v, err := strconv.ParseInt(text.String(), 10, 8)
 	if err != nil {
 		return fmt.Errorf("expected int8, got %q", text.String())
@@ -97,6 +162,7 @@ func (d *int8Codec) Decode(ptr unsafe.Pointer, text Reader) error {
 }
 
 type int16Codec struct {
+	esc Esc
 }
 
 func (d *int16Codec) Encode(ptr unsafe.Pointer, text Writer) error {
@@ -106,6 +172,10 @@ func (d *int16Codec) Encode(ptr unsafe.Pointer, text Writer) error {
 }
 
 func (d *int16Codec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*int16)(ptr) = 0
+		return nil
+	}
 	v, err := strconv.ParseInt(text.String(), 10, 16)
 	if err != nil {
 		return err
@@ -118,6 +188,7 @@ func (d *int16Codec) Decode(ptr unsafe.Pointer, text Reader) error {
 }
 
 type uint16Codec struct {
+	esc Esc
 }
 
 func (d *uint16Codec) Encode(ptr unsafe.Pointer, text Writer) error {
@@ -127,6 +198,10 @@ func (d *uint16Codec) Encode(ptr unsafe.Pointer, text Writer) error {
 }
 
 func (d *uint16Codec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*uint16)(ptr) = 0
+		return nil
+	}
 	v, err := strconv.ParseUint(text.String(), 10, 16)
 	if err != nil {
 		return err
@@ -139,6 +214,7 @@ func (d *uint16Codec) Decode(ptr unsafe.Pointer, text Reader) error {
 }
 
 type int32Codec struct {
+	esc Esc
 }
 
 func (d *int32Codec) Encode(ptr unsafe.Pointer, text Writer) error {
@@ -148,6 +224,10 @@ func (d *int32Codec) Encode(ptr unsafe.Pointer, text Writer) error {
 }
 
 func (d *int32Codec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*int32)(ptr) = 0
+		return nil
+	}
 	v, err := strconv.ParseInt(text.String(), 10, 32)
 	if err != nil {
 		return err
@@ -160,6 +240,7 @@ func (d *int32Codec) Decode(ptr unsafe.Pointer, text Reader) error {
 }
 
 type uint32Codec struct {
+	esc Esc
 }
 
 func (d *uint32Codec) Encode(ptr unsafe.Pointer, text Writer) error {
@@ -169,6 +250,10 @@ func (d *uint32Codec) Encode(ptr unsafe.Pointer, text Writer) error {
 }
 
 func (d *uint32Codec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*uint32)(ptr) = 0
+		return nil
+	}
 	v, err := strconv.ParseUint(text.String(), 10, 32)
 	if err != nil {
 		return err
@@ -181,6 +266,7 @@ func (d *uint32Codec) Decode(ptr unsafe.Pointer, text Reader) error {
 }
 
 type int64Codec struct {
+	esc Esc
 }
 
 func (d *int64Codec) Encode(ptr unsafe.Pointer, text Writer) error {
@@ -190,6 +276,10 @@ func (d *int64Codec) Encode(ptr unsafe.Pointer, text Writer) error {
 }
 
 func (d *int64Codec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*int64)(ptr) = 0
+		return nil
+	}
 	v, err := strconv.ParseInt(text.String(), 10, 64)
 	if err != nil {
 		return err
@@ -202,6 +292,7 @@ func (d *int64Codec) Decode(ptr unsafe.Pointer, text Reader) error {
 }
 
 type uint64Codec struct {
+	esc Esc
 }
 
 func (d *uint64Codec) Encode(ptr unsafe.Pointer, text Writer) error {
@@ -211,6 +302,10 @@ func (d *uint64Codec) Encode(ptr unsafe.Pointer, text Writer) error {
 }
 
 func (d *uint64Codec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*uint64)(ptr) = 0
+		return nil
+	}
 	v, err := strconv.ParseUint(text.String(), 10, 64)
 	if err != nil {
 		return err
@@ -223,6 +318,7 @@ func (d *uint64Codec) Decode(ptr unsafe.Pointer, text Reader) error {
 }
 
 type intCodec struct {
+	esc Esc
 }
 
 func (d *intCodec) Encode(ptr unsafe.Pointer, text Writer) error {
@@ -232,6 +328,10 @@ func (d *intCodec) Encode(ptr unsafe.Pointer, text Writer) error {
 }
 
 func (d *intCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*int)(ptr) = 0
+		return nil
+	}
 	v, err := strconv.ParseInt(text.String(), 10, 0)
 	if err != nil {
 		return err
@@ -241,6 +341,7 @@ func (d *intCodec) Decode(ptr unsafe.Pointer, text Reader) error {
 }
 
 type uintCodec struct {
+	esc Esc
 }
 
 func (d *uintCodec) Encode(ptr unsafe.Pointer, text Writer) error {
@@ -250,6 +351,10 @@ func (d *uintCodec) Encode(ptr unsafe.Pointer, text Writer) error {
 }
 
 func (d *uintCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*uint)(ptr) = 0
+		return nil
+	}
 	v, err := strconv.ParseUint(text.String(), 10, 0)
 	if err != nil {
 		return err
@@ -258,6 +363,89 @@ func (d *uintCodec) Decode(ptr unsafe.Pointer, text Reader) error {
 	return nil
 }
 
+// formatFloat formats v the way nginx prints its timing variables, with
+// millisecond precision, unless that would lose precision.
+func formatFloat(v float64, bitSize int) string {
+	s := strconv.FormatFloat(v, 'f', 3, bitSize)
+	if f, err := strconv.ParseFloat(s, bitSize); err == nil && f == v {
+		return s
+	}
+	return strconv.FormatFloat(v, 'f', -1, bitSize)
+}
+
+// parseFloat parses a decimal number such as nginx prints, e.g. 0.003,
+// rejecting the other syntaxes strconv.ParseFloat accepts: exponents, hex
+// floats, NaN and Inf.
+func parseFloat(str string, bitSize int) (float64, error) {
+	if !isDecimal(str) {
+		return 0, &strconv.NumError{Func: "ParseFloat", Num: string(append([]byte(nil), str...)), Err: strconv.ErrSyntax}
+	}
+	return strconv.ParseFloat(str, bitSize)
+}
+
+// isDecimal reports whether str is digits with an optional sign and dot.
+func isDecimal(str string) bool {
+	digits, dot := 0, false
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; {
+		case isDigit(c):
+			digits++
+		case c == '.' && !dot:
+			dot = true
+		case (c == '-' || c == '+') && i == 0:
+		default:
+			return false
+		}
+	}
+	return digits > 0
+}
+
+type float32Codec struct {
+	esc Esc
+}
+
+func (d *float32Codec) Encode(ptr unsafe.Pointer, text Writer) error {
+	v := *(*float32)(ptr)
+	text.WriteString(formatFloat(float64(v), 32))
+	return nil
+}
+
+func (d *float32Codec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*float32)(ptr) = 0
+		return nil
+	}
+	v, err := parseFloat(text.String(), 32)
+	if err != nil {
+		return err
+	}
+	*(*float32)(ptr) = float32(v)
+	return nil
+}
+
+type float64Codec struct {
+	esc Esc
+}
+
+func (d *float64Codec) Encode(ptr unsafe.Pointer, text Writer) error {
+	v := *(*float64)(ptr)
+	text.WriteString(formatFloat(v, 64))
+	return nil
+}
+
+func (d *float64Codec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*float64)(ptr) = 0
+		return nil
+	}
+	v, err := parseFloat(text.String(), 64)
+	if err != nil {
+		return err
+	}
+	*(*float64)(ptr) = v
+	return nil
+}
+
 type boolCodec struct {
 }
 
@@ -279,6 +467,8 @@ func (d *boolCodec) Decode(ptr unsafe.Pointer, text Reader) error {
 	return nil
 }
 
+// ptrCodec decodes the nil marker of the escaping, esc, to a nil pointer, and
+// encodes nil pointers to it.
 type ptrCodec struct {
 	esc   string
 	codec Codec
@@ -294,7 +484,11 @@ func (d *ptrCodec) Encode(ptr unsafe.Pointer, text Writer) error {
 }
 
 func (d *ptrCodec) Decode(ptr unsafe.Pointer, text Reader) error {
-	if ptr == nil || *((*unsafe.Pointer)(ptr)) == nil {
+	if text.String() == d.esc {
+		*((*unsafe.Pointer)(ptr)) = nil
+		return nil
+	}
+	if *((*unsafe.Pointer)(ptr)) == nil {
 		*((*unsafe.Pointer)(ptr)) = d.typ.UnsafeNew()
 	}
 	return d.codec.Decode(*((*unsafe.Pointer)(ptr)), text)
@@ -320,7 +514,7 @@ func (d *bytesCodec) Encode(ptr unsafe.Pointer, text Writer) error {
 	if ptr == nil {
 		return nil
 	}
-	_, err := text.Write(d.esc.Escape(*(*[]byte)(unsafe.Pointer(&ptr))))
+	_, err := text.Write(d.esc.Escape(*(*[]byte)(ptr)))
 	return err
 }
 
@@ -328,8 +522,8 @@ func (d *bytesCodec) Decode(ptr unsafe.Pointer, text Reader) error {
 	if ptr == nil {
 		return nil
 	}
-	b := text.NewBytes()
-	*(*reflect.SliceHeader)(ptr) = *(*reflect.SliceHeader)(unsafe.Pointer(&b))
+	// a fresh copy, the caller may keep the slice of a previous line
+	*(*[]byte)(ptr) = text.NewBytes()
 	return nil
 }
 
@@ -350,6 +544,6 @@ func (d *stringCodec) Decode(ptr unsafe.Pointer, text Reader) error {
 	if ptr == nil {
 		return nil
 	}
-	*((*string)(ptr)) = text.String()
+	*((*string)(ptr)) = text.NewString()
 	return nil
 }
@@ -0,0 +1,70 @@
+package ngx
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"github.com/modern-go/reflect2"
+)
+
+var anyType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// codecOfAny returns the codec of an interface{} bound to the variable name,
+// which holds values of the type the catalog gives to name, or strings for
+// unknown variables.
+func codecOfAny(ngx *NGX, name string) (Codec, error) {
+	typ := stringType
+	if v := LookupVariable(name); v != nil {
+		typ = v.Type
+	}
+	vtyp := reflect2.Type2(typ)
+	codec, err := codecOfVar(ngx, name, vtyp)
+	if err != nil {
+		return nil, err
+	}
+	return &anyCodec{ngx.esc, vtyp, codec}, nil
+}
+
+// anyCodec decodes the nil marker, or an empty value that is not a string,
+// into a nil interface{}. An upstream list that its type cannot hold back
+// exactly is kept as a string.
+type anyCodec struct {
+	esc   Esc
+	typ   reflect2.Type
+	codec Codec
+}
+
+func (d *anyCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	v := *(*interface{})(ptr)
+	switch {
+	case v == nil:
+		text.WriteString(d.esc.Nil())
+		return nil
+	case reflect2.TypeOf(v) == d.typ:
+		return d.codec.Encode(reflect2.PtrOf(v), text)
+	}
+	s, ok := v.(string)
+	if !ok {
+		s = fmt.Sprint(v)
+	}
+	_, err := text.Write(d.esc.Escape(NewStringReader(s).Bytes()))
+	return err
+}
+
+func (d *anyCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.String() == d.esc.Nil() || (text.Len() == 0 && d.typ.Kind() != reflect.String) {
+		*(*interface{})(ptr) = nil
+		return nil
+	}
+	v := d.typ.UnsafeNew()
+	if err := d.codec.Decode(v, text); err != nil {
+		if err != ErrUpstreamGroups && err != ErrUpstreamNil {
+			return err
+		}
+		*(*interface{})(ptr) = text.NewString()
+		return nil
+	}
+	*(*interface{})(ptr) = d.typ.UnsafeIndirect(v)
+	return nil
+}
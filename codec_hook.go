@@ -0,0 +1,160 @@
+package ngx
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	"github.com/modern-go/reflect2"
+)
+
+var (
+	typeCodecs sync.Map // reflect.Type -> Codec
+
+	marshalerType       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	generatedType       = reflect.TypeOf((*Generated)(nil)).Elem()
+)
+
+// RegisterTypeCodec makes every NGX encode and decode values of typ with
+// codec, which reads and writes them unescaped. It takes precedence over the
+// built-in codecs and the Marshaler and encoding.TextMarshaler interfaces,
+// and must be called before typ is first used, typically from init.
+func RegisterTypeCodec(typ reflect.Type, codec Codec) {
+	typeCodecs.Store(typ, codec)
+}
+
+// registeredCodec returns the codec registered for typ, or nil.
+func registeredCodec(typ reflect2.Type, esc Esc) Codec {
+	codec, ok := typeCodecs.Load(typ.Type1())
+	if !ok {
+		return nil
+	}
+	if esc == EscNone {
+		return codec.(Codec)
+	}
+	return &escapeCodec{esc, codec.(Codec)}
+}
+
+// codecOfHook returns the codec of a type that has its own text encoding, or
+// nil. Ngx's Marshaler and Unmarshaler take precedence over their encoding
+// counterparts, which are only honored if text is true. esc escapes what they
+// write. Methods promoted from an embedded field are not the type's own
+// encoding, and are ignored.
+func codecOfHook(ngx *NGX, typ reflect2.Type, esc Esc, text bool) Codec {
+	if codec := registeredCodec(typ, esc); codec != nil {
+		return codec
+	}
+	if ownMethod(typ.Type1(), generatedType) {
+		v := reflect.New(typ.Type1()).Interface().(Generated)
+		if v.NGXFormat() != ngx.src || ngx.opts.Lenient || ngx.opts.Backtrack {
+			return nil
+		}
+	}
+	d := &hookCodec{
+		esc:       esc,
+		ptrType:   reflect2.Type2(reflect.PtrTo(typ.Type1())),
+		marshal:   ownMethod(typ.Type1(), marshalerType),
+		unmarshal: ownMethod(typ.Type1(), unmarshalerType),
+	}
+	if text {
+		d.textMarshal = !d.marshal && ownMethod(typ.Type1(), textMarshalerType)
+		d.textUnmarshal = !d.unmarshal && ownMethod(typ.Type1(), textUnmarshalerType)
+	}
+	if !d.marshal && !d.unmarshal && !d.textMarshal && !d.textUnmarshal {
+		return nil
+	}
+	// types implementing a single direction use their kind for the other
+	d.fallback, _ = codecOfKind(ngx, typ)
+	return d
+}
+
+// ownMethod reports whether *typ implements iface, with methods that are not
+// all promoted from an embedded field of a struct.
+func ownMethod(typ, iface reflect.Type) bool {
+	if !reflect.PtrTo(typ).Implements(iface) {
+		return false
+	}
+	if typ.Kind() == reflect.Struct {
+		for i := 0; i < typ.NumField(); i++ {
+			if f := typ.Field(i); f.Anonymous && reflect.PtrTo(f.Type).Implements(iface) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hookCodec calls the Marshaler and Unmarshaler methods of a value, or their
+// encoding counterparts.
+type hookCodec struct {
+	esc           Esc
+	ptrType       reflect2.Type
+	marshal       bool
+	unmarshal     bool
+	textMarshal   bool
+	textUnmarshal bool
+	fallback      Codec
+}
+
+func (d *hookCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	var (
+		data []byte
+		err  error
+	)
+	v := d.ptrType.UnsafeIndirect(unsafe.Pointer(&ptr))
+	switch {
+	case d.marshal:
+		data, err = v.(Marshaler).MarshalNGX()
+	case d.textMarshal:
+		data, err = v.(encoding.TextMarshaler).MarshalText()
+	default:
+		if d.fallback == nil {
+			return fmt.Errorf("%s cannot be marshaled", d.ptrType.Type1().Elem())
+		}
+		return d.fallback.Encode(ptr, text)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = text.Write(d.esc.Escape(data))
+	return err
+}
+
+func (d *hookCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	v := d.ptrType.UnsafeIndirect(unsafe.Pointer(&ptr))
+	switch {
+	case d.unmarshal:
+		return v.(Unmarshaler).UnmarshalNGX(text.Bytes())
+	case d.textUnmarshal:
+		return v.(encoding.TextUnmarshaler).UnmarshalText(text.Bytes())
+	}
+	if d.fallback == nil {
+		return fmt.Errorf("%s cannot be unmarshaled", d.ptrType.Type1().Elem())
+	}
+	return d.fallback.Decode(ptr, text)
+}
+
+// escapeCodec escapes the text written by a codec that does not.
+type escapeCodec struct {
+	esc   Esc
+	codec Codec
+}
+
+func (d *escapeCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	w := AcquireWriter()
+	defer ReleaseWriter(w)
+	if err := d.codec.Encode(ptr, w); err != nil {
+		return err
+	}
+	_, err := text.Write(d.esc.Escape(w.Bytes()))
+	return err
+}
+
+func (d *escapeCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	return d.codec.Decode(ptr, text)
+}
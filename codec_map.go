@@ -1,8 +1,8 @@
 package ngx
 
 import (
-	"bytes"
-	"fmt"
+	"reflect"
+	"strings"
 	"unsafe"
 
 	"github.com/modern-go/reflect2"
@@ -10,7 +10,9 @@ import (
 
 type mapOp struct {
 	baseOp
-	KeyV unsafe.Pointer
+	KeyV  unsafe.Pointer
+	Codec Codec
+	Path  []string // keys of the nested maps of a dotted variable
 }
 
 func codecOfMap(ngx *NGX, typ *reflect2.UnsafeMapType) (Codec, error) {
@@ -19,11 +21,14 @@ func codecOfMap(ngx *NGX, typ *reflect2.UnsafeMapType) (Codec, error) {
 		return nil, err
 	}
 
-	elemCodec, err := codecOf(ngx, typ.Elem())
+	elemCodec, err := codecOfVar(ngx, "", typ.Elem())
 	if err != nil {
 		return nil, err
 	}
 
+	// dotted variables decode into nested maps of map[string]interface{}
+	nested := typ.Key().Kind() == reflect.String && typ.Elem().Type1() == anyType
+
 	ops := make([]mapOp, len(ngx.ops))
 	for i := 0; i < len(ngx.ops); i++ {
 		ops[i].baseOp = ngx.ops[i]
@@ -32,6 +37,12 @@ func codecOfMap(ngx *NGX, typ *reflect2.UnsafeMapType) (Codec, error) {
 				continue
 			}
 			ops[i].Type = ngxBind
+			if name := string(ops[i].Extra); nested && strings.IndexByte(name, '.') > 0 {
+				ops[i].Path = strings.Split(name, ".")
+			}
+			if ops[i].Codec, err = codecOfVar(ngx, string(ops[i].Extra), typ.Elem()); err != nil {
+				return nil, err
+			}
 		}
 		ops[i].KeyV = typ.Key().UnsafeNew()
 		if err := keyCodec.Decode(ops[i].KeyV, NewBytesReader(ops[i].Extra)); err != nil {
@@ -39,20 +50,27 @@ func codecOfMap(ngx *NGX, typ *reflect2.UnsafeMapType) (Codec, error) {
 		}
 	}
 
-	return &mapCodec{
+	d := &mapCodec{
 		ops:       ops,
 		esc:       ngx.esc,
+		ngx:       ngx,
 		mapType:   typ,
 		keyType:   typ.Key(),
 		elemType:  typ.Elem(),
 		keyCodec:  keyCodec,
 		elemCodec: elemCodec,
-	}, nil
+	}
+	d.checkFn = newChecker(len(ops), func(i int) (Codec, reflect2.Type) {
+		return ops[i].Codec, typ.Elem()
+	}).check
+	return d, nil
 }
 
 type mapCodec struct {
-	ops []mapOp
-	esc Esc
+	ops     []mapOp
+	esc     Esc
+	ngx     *NGX
+	checkFn func(i int, raw []byte) bool // a checker's check, bound once
 
 	mapType   *reflect2.UnsafeMapType
 	keyType   reflect2.Type
@@ -75,8 +93,19 @@ func (d *mapCodec) Encode(ptr unsafe.Pointer, text Writer) error {
 		case ngxVariable:
 			// skip
 		case ngxBind:
-			val := d.mapType.UnsafeGetIndex(ptr, op.KeyV)
-			if err := d.elemCodec.Encode(val, text); err != nil {
+			var val unsafe.Pointer
+			if op.Path != nil {
+				if v, ok := getPath(*(*map[string]interface{})(ptr), op.Path); ok {
+					val = unsafe.Pointer(&v)
+				}
+			} else {
+				val = d.mapType.UnsafeGetIndex(ptr, op.KeyV)
+			}
+			if val == nil {
+				text.WriteString(d.esc.Nil())
+				continue
+			}
+			if err := op.Codec.Encode(val, text); err != nil {
 				return err
 			}
 		}
@@ -85,115 +114,110 @@ func (d *mapCodec) Encode(ptr unsafe.Pointer, text Writer) error {
 }
 
 func (d *mapCodec) Decode(ptr unsafe.Pointer, text Reader) error {
-	p := 0
+	st := acquireState(len(d.ops))
+	defer releaseState(st)
+	spans := st.spans
+	if err := d.ngx.splitWith(text.Bytes(), spans, d.checkFn); err != nil {
+		return err
+	}
+	var errs FieldErrors
 	data := text.Bytes()
-	length := len(d.ops)
-	for i := 0; i < length; i++ {
-		op := d.ops[i]
-		switch op.Type {
-		case ngxString, ngxEscString:
-			if !bytes.HasPrefix(data[p:], op.Extra) {
-				got := data[p:]
-				if len(got) > len(op.Extra) {
-					got = got[:len(op.Extra)]
-				}
-				return fmt.Errorf("got unexpected string %q, expecting %q", got, op.Extra)
-			}
-			p += len(op.Extra)
-		case ngxVariable:
-			if i+1 >= length {
-				return nil
-			}
-			next := d.ops[i+1]
-			switch next.Type {
-			case ngxString:
-				off := bytes.Index(data[p:], next.Extra)
-				if off < 0 {
-					return fmt.Errorf("got unexpected EOF: expecting %q after $%s", next.Extra, op.Extra)
-				}
-				i++
-				p += off + len(next.Extra)
-			case ngxEscString:
-			ngx_var_retry:
-				off := bytes.Index(data[p:], next.Extra)
-				if off < 0 {
-					return fmt.Errorf("got unexpected EOF: expecting %q after $%s", next.Extra, op.Extra)
-				} else if off > 0 && data[p+off-1] == '\\' {
-					if d.esc == EscJson {
-						if _, err := d.esc.Unescape(data[p : p+off]); err != nil {
-							p += off + len(next.Extra)
-							goto ngx_var_retry
-						}
-					} else {
-						p += off + len(next.Extra)
-						goto ngx_var_retry
-					}
-				}
-				i++
-				p += off + len(next.Extra)
-			default:
-				return fmt.Errorf("ngx-go does not support '$%s$%s' style format", op.Extra, next.Extra)
-			}
-		case ngxBind:
-			var (
-				raw []byte
-				err error
-			)
-			if i+1 >= length {
-				raw = data[p:]
-			} else {
-				next := d.ops[i+1]
-				switch next.Type {
-				case ngxString:
-					off := bytes.Index(data[p:], next.Extra)
-					if off < 0 {
-						return fmt.Errorf("got unexpected EOF: expecting %q after $%s", next.Extra, op.Extra)
-					}
-					raw = data[p : p+off]
-					i++
-					p += off + len(next.Extra)
-				case ngxEscString:
-					oldp := p
-				ngx_bind_retry:
-					off := bytes.Index(data[p:], next.Extra)
-					if off < 0 {
-						return fmt.Errorf("got unexpected EOF: expecting %q after $%s", next.Extra, op.Extra)
-					} else if off > 0 && data[p+off-1] == '\\' {
-						if d.esc == EscJson {
-							if raw, err = d.esc.Unescape(data[oldp : p+off]); err == nil {
-								i++
-								p += off + len(next.Extra)
-								goto afterUnescape
-							}
-						}
-						p += off + len(next.Extra)
-						goto ngx_bind_retry
-					}
-					raw = data[oldp : p+off]
-					i++
-					p += off + len(next.Extra)
-				default:
-					return fmt.Errorf("ngx-go does not support '$%s$%s' style format", op.Extra, next.Extra)
-				}
+	for i := range d.ops {
+		op := &d.ops[i]
+		if op.Type != ngxBind {
+			continue
+		}
+		elem := d.elemType.UnsafeNew()
+		value, err := st.value(d.esc, text, i)
+		if err == nil {
+			err = op.Codec.Decode(elem, value)
+		}
+		if err != nil {
+			if !d.ngx.opts.Lenient {
+				return fieldError(data, spans[i], i, op.baseOp, "", err)
 			}
+			errs = append(errs, fieldError(data, spans[i], i, op.baseOp, "", err))
+			continue
+		}
+		if op.Path != nil {
+			setPath(*(*map[string]interface{})(ptr), op.Path, *(*interface{})(elem))
+			continue
+		}
+		d.mapType.UnsafeSetIndex(ptr, op.KeyV, elem)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
 
-			raw, err = d.esc.Unescape(raw)
-			if err != nil {
-				return err
-			}
+// getPath returns the value of m at path, through its nested maps.
+func getPath(m map[string]interface{}, path []string) (interface{}, bool) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		m = next
+	}
+	v, ok := m[path[len(path)-1]]
+	return v, ok
+}
 
-		afterUnescape:
-			elem := d.elemType.UnsafeNew()
-			if err := d.elemCodec.Decode(elem, NewBytesReader(raw)); err != nil {
-				return err
-			}
+// setPath sets the value of m at path, creating the nested maps it goes
+// through, and replacing the values that are not.
+func setPath(m map[string]interface{}, path []string, v interface{}) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[key] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = v
+}
+
+func codecOfEntry(ngx *NGX, name, key string, typ *reflect2.UnsafeMapType) (Codec, error) {
+	elemCodec, err := codecOfVar(ngx, name, typ.Elem())
+	if err != nil {
+		return nil, err
+	}
+	keyV := typ.Key().UnsafeNew()
+	*(*string)(keyV) = key
+	return &entryCodec{ngx.esc, typ, typ.Elem(), keyV, elemCodec}, nil
+}
 
-			d.mapType.UnsafeSetIndex(ptr, op.KeyV, elem)
+// entryCodec decodes a variable into the element of a map, which it creates
+// if nil, and encodes missing elements to the nil marker.
+type entryCodec struct {
+	esc       Esc
+	mapType   *reflect2.UnsafeMapType
+	elemType  reflect2.Type
+	keyV      unsafe.Pointer
+	elemCodec Codec
+}
 
-		default:
-			return fmt.Errorf("Unsupported operator type(%d)", op.Type)
-		}
+func (d *entryCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	var val unsafe.Pointer
+	if *(*unsafe.Pointer)(ptr) != nil {
+		val = d.mapType.UnsafeGetIndex(ptr, d.keyV)
 	}
+	if val == nil {
+		text.WriteString(d.esc.Nil())
+		return nil
+	}
+	return d.elemCodec.Encode(val, text)
+}
 
+func (d *entryCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	elem := d.elemType.UnsafeNew()
+	if err := d.elemCodec.Decode(elem, text); err != nil {
+		return err
+	}
+	if *(*unsafe.Pointer)(ptr) == nil {
+		*(*unsafe.Pointer)(ptr) = *(*unsafe.Pointer)(d.mapType.UnsafeMakeMap(0))
+	}
+	d.mapType.UnsafeSetIndex(ptr, d.keyV, elem)
 	return nil
 }
@@ -0,0 +1,60 @@
+package ngx
+
+import (
+	"reflect"
+	"unsafe"
+
+	"github.com/modern-go/reflect2"
+)
+
+// isOptional reports whether typ is an optional value in the style of
+// sql.NullString, a struct of the value followed by a Valid bool.
+func isOptional(typ reflect2.Type) bool {
+	if typ.Kind() != reflect.Struct {
+		return false
+	}
+	st := typ.Type1()
+	return st.NumField() == 2 && st.Field(0).PkgPath == "" &&
+		st.Field(1).Name == "Valid" && st.Field(1).Type.Kind() == reflect.Bool
+}
+
+func codecOfOptional(ngx *NGX, name string, typ *reflect2.UnsafeStructType) (Codec, error) {
+	value, valid := typ.Field(0), typ.Field(1)
+	codec, err := codecOfVar(ngx, name, value.Type())
+	if err != nil {
+		return nil, err
+	}
+	return &optionalCodec{ngx.esc, codec, value.Type(), value.Offset(), valid.Offset()}, nil
+}
+
+// optionalCodec decodes the nil marker to an invalid optional value, and
+// encodes invalid ones to it.
+type optionalCodec struct {
+	esc   Esc
+	codec Codec
+	typ   reflect2.Type
+	value uintptr
+	valid uintptr
+}
+
+func (d *optionalCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	if !*(*bool)(unsafe.Pointer(uintptr(ptr) + d.valid)) {
+		text.WriteString(d.esc.Nil())
+		return nil
+	}
+	return d.codec.Encode(unsafe.Pointer(uintptr(ptr)+d.value), text)
+}
+
+func (d *optionalCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	value, valid := unsafe.Pointer(uintptr(ptr)+d.value), (*bool)(unsafe.Pointer(uintptr(ptr)+d.valid))
+	if text.String() == d.esc.Nil() {
+		d.typ.UnsafeSet(value, d.typ.UnsafeNew())
+		*valid = false
+		return nil
+	}
+	if err := d.codec.Decode(value, text); err != nil {
+		return err
+	}
+	*valid = true
+	return nil
+}
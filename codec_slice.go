@@ -0,0 +1,159 @@
+package ngx
+
+import (
+	"reflect"
+	"unsafe"
+
+	"github.com/modern-go/reflect2"
+)
+
+// codecOfRoot returns the codec of a value holding a whole log line. Unlike
+// codecOf, it fills slices and arrays with the variables by position, and
+// only honors Marshaler and Unmarshaler, whose line it does not escape.
+func codecOfRoot(ngx *NGX, typ reflect2.Type) (Codec, error) {
+	if codec := codecOfHook(ngx, typ, EscNone, false); codec != nil {
+		return codec, nil
+	}
+	switch typ.Kind() {
+	case reflect.Slice:
+		if t := typ.(*reflect2.UnsafeSliceType); t.Elem().Kind() != reflect.Uint8 {
+			return codecOfSlice(ngx, t.Elem(), t, nil)
+		}
+	case reflect.Array:
+		t := typ.(*reflect2.UnsafeArrayType)
+		return codecOfSlice(ngx, t.Elem(), nil, t)
+	}
+	// the encoding interfaces are for values, not whole lines
+	return codecOfKind(ngx, typ)
+}
+
+type sliceOp struct {
+	baseOp
+	Index int
+	Codec Codec
+}
+
+func codecOfSlice(ngx *NGX, elemType reflect2.Type, sliceType *reflect2.UnsafeSliceType, arrayType *reflect2.UnsafeArrayType) (Codec, error) {
+	ops := make([]sliceOp, len(ngx.ops))
+	n := 0
+	for i := 0; i < len(ngx.ops); i++ {
+		ops[i].baseOp = ngx.ops[i]
+		if ops[i].Type != ngxVariable {
+			continue
+		}
+		codec, err := codecOfVar(ngx, string(ops[i].Extra), elemType)
+		if err != nil {
+			return nil, err
+		}
+		ops[i].Type = ngxBind
+		ops[i].Index = n
+		ops[i].Codec = codec
+		n++
+	}
+
+	d := &sliceCodec{
+		ops:       ops,
+		esc:       ngx.esc,
+		ngx:       ngx,
+		vars:      n,
+		elemType:  elemType,
+		sliceType: sliceType,
+		arrayType: arrayType,
+	}
+	if arrayType != nil {
+		d.arrayLen = arrayType.Type1().Len()
+	}
+	d.checkFn = newChecker(len(ops), func(i int) (Codec, reflect2.Type) {
+		return ops[i].Codec, elemType
+	}).check
+	return d, nil
+}
+
+// sliceCodec stores the i-th variable of a line in the i-th element of a
+// slice or an array. Arrays shorter than the number of variables drop the
+// extra values, and longer ones leave the extra elements untouched.
+type sliceCodec struct {
+	ops     []sliceOp
+	esc     Esc
+	ngx     *NGX
+	checkFn func(i int, raw []byte) bool // a checker's check, bound once
+
+	vars      int
+	elemType  reflect2.Type
+	sliceType *reflect2.UnsafeSliceType
+	arrayType *reflect2.UnsafeArrayType
+	arrayLen  int
+}
+
+// elem returns a pointer to the i-th element, or nil if there is none.
+func (d *sliceCodec) elem(ptr unsafe.Pointer, i int) unsafe.Pointer {
+	if d.sliceType != nil {
+		if i >= d.sliceType.UnsafeLengthOf(ptr) {
+			return nil
+		}
+		return d.sliceType.UnsafeGetIndex(ptr, i)
+	}
+	if i >= d.arrayLen {
+		return nil
+	}
+	return d.arrayType.UnsafeGetIndex(ptr, i)
+}
+
+func (d *sliceCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	for _, op := range d.ops {
+		switch op.Type {
+		case ngxString, ngxEscString:
+			text.Write(op.Extra)
+		case ngxBind:
+			elem := d.elem(ptr, op.Index)
+			if elem == nil {
+				text.WriteString(d.esc.Nil())
+				continue
+			}
+			if err := op.Codec.Encode(elem, text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *sliceCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	st := acquireState(len(d.ops))
+	defer releaseState(st)
+	spans := st.spans
+	if err := d.ngx.splitWith(text.Bytes(), spans, d.checkFn); err != nil {
+		return err
+	}
+	if d.sliceType != nil {
+		d.sliceType.UnsafeGrow(ptr, d.vars)
+	}
+
+	var errs FieldErrors
+	data := text.Bytes()
+	for i := range d.ops {
+		op := &d.ops[i]
+		if op.Type != ngxBind {
+			continue
+		}
+		elem := d.elem(ptr, op.Index)
+		if elem == nil {
+			continue
+		}
+		value, err := st.value(d.esc, text, i)
+		if err == nil {
+			err = op.Codec.Decode(elem, value)
+		}
+		if err != nil {
+			if !d.ngx.opts.Lenient {
+				return fieldError(data, spans[i], i, op.baseOp, "", err)
+			}
+			d.elemType.UnsafeSet(elem, d.elemType.UnsafeNew())
+			errs = append(errs, fieldError(data, spans[i], i, op.baseOp, "", err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
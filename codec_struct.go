@@ -1,8 +1,9 @@
 package ngx
 
 import (
-	"bytes"
 	"fmt"
+	"reflect"
+	"strings"
 	"unsafe"
 
 	"github.com/modern-go/reflect2"
@@ -12,6 +13,8 @@ type structOp struct {
 	baseOp
 	Offset uintptr
 	Codec  Codec
+	Field  string
+	Typ    reflect2.Type
 }
 
 func codecOfStruct(ngx *NGX, typ *reflect2.UnsafeStructType) (Codec, error) {
@@ -19,34 +22,193 @@ func codecOfStruct(ngx *NGX, typ *reflect2.UnsafeStructType) (Codec, error) {
 	for i := 0; i < len(ops); i++ {
 		ops[i].baseOp = ngx.ops[i]
 	}
+	d := &structCodec{ops: ops, esc: ngx.esc, ngx: ngx}
+	if err := d.bind(typ, "", "", 0); err != nil {
+		return nil, err
+	}
+	if err := d.bindCatchAlls(); err != nil {
+		return nil, err
+	}
+	d.checkFn = newChecker(len(ops), func(i int) (Codec, reflect2.Type) {
+		return ops[i].Codec, ops[i].Typ
+	}).check
+	return d, nil
+}
 
+// bind binds the fields of typ, at offset in the decoded value, to the
+// variables named prefix followed by their tag or name (see fieldTag). The
+// fields of anonymous structs are bound as if they were fields of typ, and
+// struct fields that are not variables themselves bind the variables under
+// their name and a dot, so that $header.cookie lands in Header.Cookie.
+// Untagged struct fields, and untagged fields within them, match the dotted
+// variables regardless of case.
+func (d *structCodec) bind(typ *reflect2.UnsafeStructType, prefix, path string, offset uintptr) error {
+	ngx := d.ngx
 	for i := 0; i < typ.NumField(); i++ {
 		field := typ.Field(i)
-		name := field.Name()
-		tag := field.Tag().Get("ngx")
-		if name == "_" || tag == "_" {
+		tag, err := parseTag(field.Tag().Get("ngx"))
+		if err != nil {
+			return fmt.Errorf("field %s%s: %v", path, field.Name(), err)
+		}
+		names, untagged := tag.names, len(tag.names) == 0
+		if untagged {
+			names = []string{field.Name()}
+		}
+		if names[0] == "_" {
+			continue
+		}
+		if prefixes := tag.wildcards(); tag.rest || len(prefixes) > 0 {
+			mapType, ok := field.Type().(*reflect2.UnsafeMapType)
+			if !ok || mapType.Key().Kind() != reflect.String {
+				return fmt.Errorf("field %s%s: catch-all fields must be maps with string keys", path, field.Name())
+			}
+			for i := range prefixes {
+				prefixes[i] = prefix + prefixes[i]
+			}
+			d.catchAlls = append(d.catchAlls, structCatchAll{prefixes, tag.rest, offset + field.Offset(), path + field.Name(), mapType})
+			continue
+		}
+		st, isStruct := field.Type().(*reflect2.UnsafeStructType)
+		if field.Anonymous() && untagged && isStruct {
+			if err := d.bind(st, prefix, path, offset+field.Offset()); err != nil {
+				return err
+			}
 			continue
 		}
 
-		if len(tag) > 0 {
-			name = tag
+		bound := false
+		for _, name := range names {
+			varname, ok := ngx.lookupVar(prefix+name, untagged && prefix != "")
+			if !ok {
+				continue
+			}
+			ind := ngx.supported[varname]
+			d.ops[ind].Type = ngxBind
+			d.ops[ind].Offset = offset + field.Offset()
+			d.ops[ind].Field = path + field.Name()
+			d.ops[ind].Typ = field.Type()
+			dec, err := codecOfVar(ngx, varname, field.Type())
+			if err != nil {
+				return err
+			}
+			d.ops[ind].Codec = tag.codec(ngx, field.Type(), dec)
+			bound = true
+			break
 		}
-		if ind, ok := ngx.supported[name]; ok {
-			ops[ind].Type = ngxBind
-			ops[ind].Offset = field.Offset()
-			dec, err := codecOf(ngx, field.Type())
+		for _, name := range names {
+			if bound || !isStruct {
+				break
+			}
+			if varname, ok := ngx.lookupVar(prefix+name+".", untagged); ok {
+				if err := d.bind(st, varname, path+field.Name()+".", offset+field.Offset()); err != nil {
+					return err
+				}
+				bound = true
+			}
+		}
+		if bound {
+			continue
+		}
+
+		switch {
+		case tag.required:
+			return fmt.Errorf("field %s%s: %w: $%s", path, field.Name(), ErrMissingVariable, prefix+strings.Join(names, "|$"+prefix))
+		case tag.def != nil:
+			dec, err := codecOfVar(ngx, prefix+names[0], field.Type())
+			if err != nil {
+				return err
+			}
+			d.defaults = append(d.defaults, structDefault{offset + field.Offset(), dec, NewStringReader(*tag.def)})
+		default:
+			d.unbound = append(d.unbound, path+field.Name())
+		}
+	}
+	return nil
+}
+
+// structCatchAll is a map field collecting the variables no other field binds,
+// those starting with one of prefixes, or all of them if rest is true.
+type structCatchAll struct {
+	Prefixes []string
+	Rest     bool
+	Offset   uintptr
+	Field    string
+	MapType  *reflect2.UnsafeMapType
+}
+
+// key returns the key of the variable name in the map, if it collects it.
+func (c *structCatchAll) key(name string) (string, bool) {
+	if c.Rest {
+		return name, true
+	}
+	for _, prefix := range c.Prefixes {
+		if len(name) > len(prefix) && strings.HasPrefix(name, prefix) {
+			return name[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+// bindCatchAlls binds the variables left unbound by bind to the catch-all
+// fields, trying the wildcards in the order of the fields before the rest.
+func (d *structCodec) bindCatchAlls() error {
+	for i := range d.ops {
+		op := &d.ops[i]
+		name := string(op.Extra)
+		if op.Type != ngxVariable || name == "_" {
+			continue
+		}
+		for _, rest := range []bool{false, true} {
+			c, key := d.catchAll(name, rest)
+			if c == nil {
+				continue
+			}
+			codec, err := codecOfEntry(d.ngx, name, key, c.MapType)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			ops[ind].Codec = dec
+			op.Type = ngxBind
+			op.Offset = c.Offset
+			op.Field = c.Field + "[" + key + "]"
+			op.Typ = c.MapType
+			op.Codec = codec
+			break
 		}
 	}
-	return &structCodec{ops, ngx.esc}, nil
+	return nil
+}
+
+// catchAll returns the first catch-all field collecting name, wildcards or
+// rest, along with its key in the map.
+func (d *structCodec) catchAll(name string, rest bool) (*structCatchAll, string) {
+	for i := range d.catchAlls {
+		c := &d.catchAlls[i]
+		if c.Rest != rest {
+			continue
+		}
+		if key, ok := c.key(name); ok {
+			return c, key
+		}
+	}
+	return nil, ""
+}
+
+// structDefault is the default value of a field whose variables are missing
+// from the log format.
+type structDefault struct {
+	Offset uintptr
+	Codec  Codec
+	Value  *StringReader
 }
 
 type structCodec struct {
-	ops []structOp
-	esc Esc
+	ops       []structOp
+	defaults  []structDefault
+	catchAlls []structCatchAll
+	unbound   []string // fields no variable binds, for Check
+	esc       Esc
+	ngx       *NGX
+	checkFn   func(i int, raw []byte) bool // a checker's check, bound once
 }
 
 func (d *structCodec) Encode(ptr unsafe.Pointer, text Writer) error {
@@ -69,113 +231,41 @@ func (d *structCodec) Encode(ptr unsafe.Pointer, text Writer) error {
 }
 
 func (d *structCodec) Decode(ptr unsafe.Pointer, text Reader) error {
-	p := 0
+	st := acquireState(len(d.ops))
+	defer releaseState(st)
+	spans := st.spans
+	if err := d.ngx.splitWith(text.Bytes(), spans, d.checkFn); err != nil {
+		return err
+	}
+	for _, def := range d.defaults {
+		if err := def.Codec.Decode(unsafe.Pointer(uintptr(ptr)+def.Offset), def.Value); err != nil {
+			return err
+		}
+	}
+	var errs FieldErrors
 	data := text.Bytes()
-	length := len(d.ops)
-	for i := 0; i < length; i++ {
-		op := d.ops[i]
-		switch op.Type {
-		case ngxString, ngxEscString:
-			if !bytes.HasPrefix(data[p:], op.Extra) {
-				got := data[p:]
-				if len(got) > len(op.Extra) {
-					got = got[:len(op.Extra)]
-				}
-				return fmt.Errorf("got unexpected string %q, expecting %q", got, op.Extra)
-			}
-			p += len(op.Extra)
-		case ngxVariable:
-			if i+1 >= length {
-				return nil
-			}
-			next := d.ops[i+1]
-			switch next.Type {
-			case ngxString:
-				off := bytes.Index(data[p:], next.Extra)
-				if off < 0 {
-					return fmt.Errorf("got unexpected EOF: expecting %q after $%s", next.Extra, op.Extra)
-				}
-				i++
-				p += off + len(next.Extra)
-			case ngxEscString:
-			ngx_var_retry:
-				off := bytes.Index(data[p:], next.Extra)
-				if off < 0 {
-					return fmt.Errorf("got unexpected EOF: expecting %q after $%s", next.Extra, op.Extra)
-				} else if off > 0 && data[p+off-1] == '\\' {
-					if d.esc == EscJson {
-						if _, err := d.esc.Unescape(data[p : p+off]); err != nil {
-							p += off + len(next.Extra)
-							goto ngx_var_retry
-						}
-					} else {
-						p += off + len(next.Extra)
-						goto ngx_var_retry
-					}
-				}
-				i++
-				p += off + len(next.Extra)
-			default:
-				return fmt.Errorf("ngx-go does not support '$%s$%s' style format", op.Extra, next.Extra)
-			}
-		case ngxBind:
-			var (
-				raw []byte
-				err error
-			)
-			if i+1 >= length {
-				raw = data[p:]
-			} else {
-				next := d.ops[i+1]
-				switch next.Type {
-				case ngxString:
-					off := bytes.Index(data[p:], next.Extra)
-					if off < 0 {
-						return fmt.Errorf("got unexpected EOF: expecting %q after $%s", next.Extra, op.Extra)
-					}
-					raw = data[p : p+off]
-					i++
-					p += off + len(next.Extra)
-				case ngxEscString:
-					oldp := p
-				ngx_bind_retry:
-					off := bytes.Index(data[p:], next.Extra)
-					if off < 0 {
-						return fmt.Errorf("got unexpected EOF: expecting %q after $%s", next.Extra, op.Extra)
-					} else if off > 0 && data[p+off-1] == '\\' {
-						if d.esc == EscJson {
-							if raw, err = d.esc.Unescape(data[oldp : p+off]); err == nil {
-								i++
-								p += off + len(next.Extra)
-								goto afterUnescape
-							}
-						}
-						p += off + len(next.Extra)
-						goto ngx_bind_retry
-					}
-					raw = data[oldp : p+off]
-					i++
-					p += off + len(next.Extra)
-				default:
-					return fmt.Errorf("ngx-go does not support '$%s$%s' style format", op.Extra, next.Extra)
-				}
-			}
-
-			raw, err = d.esc.Unescape(raw)
-			if err != nil {
-				return err
+	for i := range d.ops {
+		op := &d.ops[i]
+		if op.Type != ngxBind {
+			continue
+		}
+		bindPtr := unsafe.Pointer(uintptr(ptr) + op.Offset)
+		value, err := st.value(d.esc, text, i)
+		if err == nil {
+			err = op.Codec.Decode(bindPtr, value)
+		}
+		if err != nil {
+			if !d.ngx.opts.Lenient {
+				return fieldError(data, spans[i], i, op.baseOp, op.Field, err)
 			}
-		afterUnescape:
-			bindPtr := unsafe.Pointer(uintptr(ptr) + op.Offset)
-
-			if err := op.Codec.Decode(bindPtr, NewBytesReader(raw)); err != nil {
-				return fmt.Errorf("field %q %v", op.Extra, err)
+			if _, ok := op.Codec.(*entryCodec); !ok {
+				op.Typ.UnsafeSet(bindPtr, op.Typ.UnsafeNew())
 			}
-
-		default:
-			return fmt.Errorf("Unsupported operator type(%d)", op.Type)
+			errs = append(errs, fieldError(data, spans[i], i, op.baseOp, op.Field, err))
 		}
 	}
-
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
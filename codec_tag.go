@@ -0,0 +1,146 @@
+package ngx
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unsafe"
+
+	"github.com/modern-go/reflect2"
+)
+
+var (
+	ErrRequired        = errors.New("Required variable is nil")
+	ErrMissingVariable = errors.New("Required variable is missing from the log format")
+)
+
+// fieldTag is the ngx tag of a struct field, names[,option...]. names lists
+// the variables the field binds to, separated by '|', the first one found in
+// the log format winning. A name ending with '*' is a wildcard, which binds a
+// map with string keys to every variable starting with the name, the keys
+// being the rest of the variable names. The options are:
+//
+//	required    fail if no name is in the log format, or if the value is nil
+//	default=v   decode v for nil values, or if no name is in the log format;
+//	            it takes the rest of the tag, commas included
+//	omitempty   encode zero values as the nil marker
+//	string      encode the value between double quotes, which are optional
+//	            when decoding
+//	rest        bind a map with string keys to every variable no other
+//	            field binds, keyed by their names
+type fieldTag struct {
+	names     []string
+	rest      bool
+	required  bool
+	omitempty bool
+	quoted    bool
+	def       *string
+}
+
+func parseTag(tag string) (fieldTag, error) {
+	var t fieldTag
+	opts := strings.Split(tag, ",")
+	if opts[0] != "" {
+		t.names = strings.Split(opts[0], "|")
+	}
+	for i := 1; i < len(opts); i++ {
+		switch opt := opts[i]; {
+		case opt == "rest":
+			t.rest = true
+		case opt == "required":
+			t.required = true
+		case opt == "omitempty":
+			t.omitempty = true
+		case opt == "string":
+			t.quoted = true
+		case strings.HasPrefix(opt, "default="):
+			def := strings.Join(opts[i:], ",")[len("default="):]
+			t.def = &def
+			i = len(opts)
+		default:
+			return t, fmt.Errorf("unknown ngx tag option %q", opt)
+		}
+	}
+	return t, nil
+}
+
+// wildcards returns the prefixes of the wildcard names of t.
+func (t *fieldTag) wildcards() []string {
+	var prefixes []string
+	for _, name := range t.names {
+		if strings.HasSuffix(name, "*") {
+			prefixes = append(prefixes, name[:len(name)-1])
+		}
+	}
+	return prefixes
+}
+
+// codec wraps codec with the options of t, if any.
+func (t *fieldTag) codec(ngx *NGX, typ reflect2.Type, codec Codec) Codec {
+	if !t.required && !t.omitempty && !t.quoted && t.def == nil {
+		return codec
+	}
+	d := &tagCodec{codec: codec, typ: typ, esc: ngx.esc, required: t.required, omitempty: t.omitempty, quoted: t.quoted}
+	if t.def != nil {
+		d.def = NewStringReader(*t.def)
+	}
+	return d
+}
+
+// decodeMissing decodes into ptr the value of a variable that is absent, as
+// the context of an error log entry may be: it fails if the field is
+// required, and decodes its default if it has one.
+func decodeMissing(codec Codec, ptr unsafe.Pointer) error {
+	d, ok := codec.(*tagCodec)
+	switch {
+	case !ok:
+		return nil
+	case d.required:
+		return ErrRequired
+	case d.def != nil:
+		return d.codec.Decode(ptr, d.def)
+	}
+	return nil
+}
+
+// tagCodec applies the options of a field tag around the codec of the field.
+type tagCodec struct {
+	codec     Codec
+	typ       reflect2.Type
+	esc       Esc
+	required  bool
+	omitempty bool
+	quoted    bool
+	def       *StringReader
+}
+
+func (d *tagCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	if d.omitempty && reflect.NewAt(d.typ.Type1(), ptr).Elem().IsZero() {
+		text.WriteString(d.esc.Nil())
+		return nil
+	}
+	if !d.quoted {
+		return d.codec.Encode(ptr, text)
+	}
+	text.WriteByte('"')
+	if err := d.codec.Encode(ptr, text); err != nil {
+		return err
+	}
+	return text.WriteByte('"')
+}
+
+func (d *tagCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.String() == d.esc.Nil() {
+		if d.required {
+			return ErrRequired
+		}
+		if d.def != nil {
+			return d.codec.Decode(ptr, d.def)
+		}
+	}
+	if b := text.Bytes(); d.quoted && len(b) >= 2 && b[0] == '"' && b[len(b)-1] == '"' {
+		return d.codec.Decode(ptr, NewBytesReader(b[1:len(b)-1]))
+	}
+	return d.codec.Decode(ptr, text)
+}
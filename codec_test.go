@@ -0,0 +1,499 @@
+package ngx
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+type timing struct {
+	Status               int     `ngx:"status"`
+	RequestTime          float64 `ngx:"request_time"`
+	UpstreamResponseTime float32 `ngx:"upstream_response_time"`
+}
+
+type times struct {
+	TimeLocal   time.Time      `ngx:"time_local"`
+	TimeISO8601 *time.Time     `ngx:"time_iso8601"`
+	Msec        time.Time      `ngx:"msec"`
+	RequestTime time.Duration  `ngx:"request_time"`
+	Upstream    *time.Duration `ngx:"upstream_response_time"`
+}
+
+type adjacent struct {
+	Scheme      string  `ngx:"scheme"`
+	Host        string  `ngx:"host"`
+	Status      int     `ngx:"status"`
+	Bytes       int     `ngx:"body_bytes_sent"`
+	RequestTime float64 `ngx:"request_time"`
+	Upstream    float64 `ngx:"upstream_response_time"`
+}
+
+type upstreams struct {
+	Addr   [][]string         `ngx:"upstream_addr"`
+	Status [][]int            `ngx:"upstream_status"`
+	Times  [][]*time.Duration `ngx:"upstream_response_time"`
+}
+
+type upstreamsFlat struct {
+	Addr   []string         `ngx:"upstream_addr"`
+	Status []int            `ngx:"upstream_status"`
+	Times  []*time.Duration `ngx:"upstream_response_time"`
+}
+
+type optionals struct {
+	User     sql.NullString  `ngx:"remote_user"`
+	Status   *int            `ngx:"upstream_status"`
+	Bytes    sql.NullInt64   `ngx:"body_bytes_sent"`
+	Time     sql.NullFloat64 `ngx:"request_time"`
+	Sent     int             `ngx:"bytes_sent"`
+	Canceled sql.NullBool    `ngx:"request_completion"`
+}
+
+type nestedBase struct {
+	Status int `ngx:"status"`
+}
+
+type nested struct {
+	nestedBase
+	Header struct {
+		Cookie    string
+		UserAgent string `ngx:"user_agent"`
+	}
+	Upstream struct {
+		Addr string
+	} `ngx:"up"`
+}
+
+type tagged struct {
+	Status   int     `ngx:"status,required"`
+	Time     float64 `ngx:"request_time|rt"`
+	Bytes    int     `ngx:"body_bytes_sent,omitempty"`
+	Upstream int     `ngx:"upstream_status,string"`
+	Scheme   string  `ngx:"scheme,default=http"`
+	Host     string  `ngx:"host,default=a,b"`
+}
+
+type catchAll struct {
+	Status  int               `ngx:"status"`
+	UA      string            `ngx:"http_user_agent"`
+	Headers map[string]string `ngx:"http_*"`
+	Cookies map[string]string `ngx:"cookie_*"`
+	Rest    map[string]string `ngx:",rest"`
+}
+
+type embedTime struct {
+	time.Time `ngx:"time_local"`
+	Status    int `ngx:"status"`
+}
+
+type requests struct {
+	Request *RequestLine `ngx:"request"`
+	Status  int          `ngx:"status"`
+}
+
+type level int
+
+func (l level) MarshalText() ([]byte, error) {
+	return []byte([]string{"info", "warn"}[l]), nil
+}
+
+func (l *level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "info":
+		*l = 0
+	case "warn":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown level %q", text)
+	}
+	return nil
+}
+
+// hexInt prefers MarshalNGX over MarshalText.
+type hexInt int
+
+func (h hexInt) MarshalNGX() ([]byte, error) {
+	return []byte(fmt.Sprintf("%#x", int(h))), nil
+}
+
+func (h *hexInt) UnmarshalNGX(data []byte) error {
+	v, err := strconv.ParseInt(string(data), 0, 0)
+	*h = hexInt(v)
+	return err
+}
+
+func (h hexInt) MarshalText() ([]byte, error) {
+	return nil, errors.New("unexpected MarshalText")
+}
+
+type registered int
+
+type hashCodec struct{}
+
+func (hashCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	text.WriteString(`#"` + strconv.Itoa(*(*int)(ptr)))
+	return nil
+}
+
+func (hashCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	v, err := strconv.Atoi(strings.TrimPrefix(text.String(), `#"`))
+	*(*int)(ptr) = v
+	return err
+}
+
+func init() {
+	RegisterTypeCodec(reflect.TypeOf(registered(0)), hashCodec{})
+}
+
+type hooks struct {
+	IP    net.IP      `ngx:"remote_addr"`
+	Level level       `ngx:"status"`
+	Hex   hexInt      `ngx:"body_bytes_sent"`
+	Reg   *registered `ngx:"request_time"`
+}
+
+// rootLine unmarshals whole lines.
+type rootLine struct {
+	line string
+}
+
+func (r rootLine) MarshalNGX() ([]byte, error) {
+	return []byte(r.line), nil
+}
+
+func (r *rootLine) UnmarshalNGX(data []byte) error {
+	r.line = string(data)
+	return nil
+}
+
+// generatedLine pretends to have generated methods for genLineFormat.
+type generatedLine struct {
+	Status int `ngx:"status"`
+	line   string
+}
+
+const genLineFormat = `$status $body_bytes_sent`
+
+func (*generatedLine) NGXFormat() string {
+	return genLineFormat
+}
+
+func (g *generatedLine) UnmarshalNGX(data []byte) error {
+	g.line = string(data)
+	return nil
+}
+
+var (
+	tz          = time.FixedZone("", 8*3600)
+	timeLocal   = time.Date(2020, 1, 2, 15, 4, 5, 0, tz)
+	iso8601     = time.Date(2020, 1, 2, 7, 4, 5, 0, time.FixedZone("", -3600))
+	msec        = time.Unix(1577948645, 123000000)
+	upstream    = 1500 * time.Microsecond
+	timeFormat  = `[$time_local] $time_iso8601 $msec $request_time $upstream_response_time`
+	adjFormat   = `$scheme$host$status $body_bytes_sent $request_time$upstream_response_time`
+	upsFormat   = `$upstream_addr $upstream_status $upstream_response_time "$request"`
+	upsData     = `10.0.0.1:80, 10.0.0.2:80 : 10.0.0.3:80 502, 504 : 200 0.001, - : 0.250 "GET / HTTP/1.1"`
+	ms, qs      = time.Millisecond, 250 * time.Millisecond
+	reg5        = registered(5)
+	optFormat   = `$remote_user $upstream_status $body_bytes_sent $request_time $bytes_sent $request_completion`
+	status502   = 502
+	catchFormat = `$status "$http_user_agent" "$http_x_id" $cookie_sid $request_id $host`
+	tagFormat   = `$status $rt $body_bytes_sent $upstream_status $scheme`
+	nestFormat  = `escape=json;{"status":$status,"cookie":"$header.cookie","ua":"$header.user_agent","up":"$up.addr"}`
+)
+
+var nestedValue = func() *nested {
+	v := &nested{nestedBase: nestedBase{200}}
+	v.Header.Cookie, v.Header.UserAgent, v.Upstream.Addr = "a=1", "curl", "10.0.0.1:80"
+	return v
+}()
+
+var positiveTyped = []struct {
+	Fmt       string
+	Data      string
+	Expected  interface{}
+	Marshaled string
+}{
+	{`$status $request_time $upstream_response_time`, `200 0.003 1.250`, &timing{200, 0.003, 1.25}, `200 0.003 1.250`},
+	{`$status $request_time $upstream_response_time`, `502 12.000 -`, &timing{502, 12, 0}, `502 12.000 0.000`},
+	{`$status $request_time $upstream_response_time`, `200 0.0001 0.5`, &timing{200, 0.0001, 0.5}, `200 0.0001 0.500`},
+	{`escape=json;{"rt":"$request_time","urt":"$upstream_response_time"}`, `{"rt":"0.120","urt":"null"}`, &timing{0, 0.12, 0}, `{"rt":"0.120","urt":"0.000"}`},
+	{timeFormat, `[02/Jan/2020:15:04:05 +0800] 2020-01-02T07:04:05-01:00 1577948645.123 0.003 0.0015`, &times{timeLocal, &iso8601, msec, 3 * time.Millisecond, &upstream}, `[02/Jan/2020:15:04:05 +0800] 2020-01-02T07:04:05-01:00 1577948645.123 0.003 0.0015`},
+	{timeFormat, `[-] - - 12.000 -`, &times{time.Time{}, nil, time.Time{}, 12 * time.Second, nil}, `[-] - - 12.000 -`},
+	{adjFormat, `httpexample.com200 512 0.0031.250`, &adjacent{"http", "example.com", 200, 512, 0.003, 1.25}, `httpexample.com200 512 0.0031.250`},
+	{adjFormat, `http-404 0 0.000-`, &adjacent{"http", "-", 404, 0, 0, 0}, `http-404 0 0.0000.000`},
+	{`$remote_addr [$status] "$request"`, `127.0.0.1 [200] "GET / HTTP/1.1"`, &[]string{"127.0.0.1", "200", "GET / HTTP/1.1"}, `127.0.0.1 [200] "GET / HTTP/1.1"`},
+	{`$remote_addr [$status] "$request"`, `127.0.0.1 [200] "GET / HTTP/1.1"`, &[3]string{"127.0.0.1", "200", "GET / HTTP/1.1"}, `127.0.0.1 [200] "GET / HTTP/1.1"`},
+	{`$remote_addr [$status] "$request"`, `127.0.0.1 [200] "GET / HTTP/1.1"`, &[2]string{"127.0.0.1", "200"}, `127.0.0.1 [200] "-"`},
+	{`$status $request_time $upstream_response_time`, `200 0.003 -`, &[]float64{200, 0.003, 0}, `200.000 0.003 0.000`},
+	{optFormat, `frank 502 512 0.003 600 true`, &optionals{sql.NullString{String: "frank", Valid: true}, &status502, sql.NullInt64{Int64: 512, Valid: true}, sql.NullFloat64{Float64: 0.003, Valid: true}, 600, sql.NullBool{Bool: true, Valid: true}}, `frank 502 512 0.003 600 true`},
+	{optFormat, `- - - - - -`, &optionals{}, `- - - - 0 -`},
+	{`escape=json;{"user":$remote_user,"status":$upstream_status}`, `{"user":null,"status":null}`, &optionals{}, `{"user":null,"status":null}`},
+	{nestFormat, `{"status":200,"cookie":"a=1","ua":"curl","up":"10.0.0.1:80"}`, nestedValue, `{"status":200,"cookie":"a=1","ua":"curl","up":"10.0.0.1:80"}`},
+	{tagFormat, `200 0.5 0 "502" -`, &tagged{200, 0.5, 0, 502, "http", "a,b"}, `200 0.500 - "502" http`},
+	{tagFormat, `200 0.5 512 502 https`, &tagged{200, 0.5, 512, 502, "https", "a,b"}, `200 0.500 512 "502" https`},
+	{catchFormat, `200 "curl" "abc" s1 r1 -`, &catchAll{200, "curl", map[string]string{"x_id": "abc"}, map[string]string{"sid": "s1"}, map[string]string{"request_id": "r1", "host": "-"}}, `200 "curl" "abc" s1 r1 -`},
+	{`[$time_local] $status`, `[02/Jan/2020:15:04:05 +0800] 200`, &embedTime{timeLocal, 200}, `[02/Jan/2020:15:04:05 +0800] 200`},
+	{upsFormat, upsData, &upstreams{[][]string{{"10.0.0.1:80", "10.0.0.2:80"}, {"10.0.0.3:80"}}, [][]int{{502, 504}, {200}}, [][]*time.Duration{{&ms, nil}, {&qs}}}, `10.0.0.1:80, 10.0.0.2:80 : 10.0.0.3:80 502, 504 : 200 0.001, - : 0.250 "-"`},
+	{upsFormat, `10.0.0.1:80 502 0.001 "GET / HTTP/1.1"`, &upstreamsFlat{[]string{"10.0.0.1:80"}, []int{502}, []*time.Duration{&ms}}, `10.0.0.1:80 502 0.001 "-"`},
+	{upsFormat, `10.0.0.1:80, - 502, 504 0.001, - "GET / HTTP/1.1"`, &upstreamsFlat{[]string{"10.0.0.1:80", "-"}, []int{502, 504}, []*time.Duration{&ms, nil}}, `10.0.0.1:80, - 502, 504 0.001, - "-"`},
+	{upsFormat, `- - - "GET / HTTP/1.1"`, &upstreams{}, `- - - "-"`},
+	{`$remote_addr "$status" $body_bytes_sent "$request_time"`, `::1 "warn" 0x1f "#\"5"`, &hooks{net.ParseIP("::1"), 1, 31, &reg5}, `::1 "warn" 0x1f "#\"5"`},
+	{`$remote_addr "$request"`, `a "b" \x22c`, &rootLine{`a "b" \x22c`}, `a "b" \x22c`},
+	{`"$request" $status`, `"GET /a?b=1 HTTP/1.1" 200`, &requests{&RequestLine{"GET", "/a?b=1", &url.URL{Path: "/a", RawQuery: "b=1"}, "HTTP/1.1", true, "GET /a?b=1 HTTP/1.1"}, 200}, `"GET /a?b=1 HTTP/1.1" 200`},
+	{`"$request" $status`, `"\x16\x03\x01\x00" 400`, &requests{&RequestLine{Method: "\x16\x03\x01\x00", Raw: "\x16\x03\x01\x00"}, 400}, `"\x16\x03\x01\x00" 400`},
+	{`$status $request_time $upstream_response_time`, `200 0.003 1.250`, &[]time.Duration{200 * time.Second, 3 * time.Millisecond, 1250 * time.Millisecond}, `200.000 0.003 1.250`},
+}
+
+var negativeTyped = []struct {
+	Fmt  string
+	Data string
+	Type interface{}
+}{
+	{`$status $request_time $upstream_response_time`, `200 fast 0.5`, &timing{}},
+	{`$status $request_time $upstream_response_time`, `200 0.1 1e40`, &timing{}},
+	{`$status $request_time $upstream_response_time`, `200 NaN 0.5`, &timing{}},
+	{`$status $request_time $upstream_response_time`, `200 0.1 -Inf`, &timing{}},
+	{`$status $request_time $upstream_response_time`, `200 0x1p-2 0.5`, &timing{}},
+	{`$status $request_time $upstream_response_time`, `200 1e3 0.5`, &timing{}},
+	{`$status $request_time $upstream_response_time`, `200 . 0.5`, &timing{}},
+	{timeFormat, `[2020-01-02T07:04:05] - - 0 0`, &times{}},
+	{timeFormat, `[-] - 1577948645,123 0 0`, &times{}},
+	{timeFormat, `[-] - - 1s 0`, &times{}},
+	{timeFormat, `[-] - - 0.0000000001 0`, &times{}},
+	{adjFormat, `httpexample.com2x0 512 0.0031.250`, &adjacent{}},
+	{adjFormat, `httpsexample.com200 512 0.0031.250`, &adjacent{}},
+	{adjFormat, `httpsite.com200 512 0.0031.250`, &adjacent{}},
+	{adjFormat, `ftpexample.com200 512 0.0031.250`, &adjacent{}},
+	{`$status $request_time`, `200 0.1s`, &[]float64{}},
+	{`$remote_addr "$status" $body_bytes_sent "$request_time"`, `::1 "error" 0x1f "#\"5"`, &hooks{}},
+	{`$remote_addr "$status" $body_bytes_sent "$request_time"`, `::1 "warn" 1f "#\"5"`, &hooks{}},
+	{upsFormat, `10.0.0.1:80 502, x : 200 - "GET / HTTP/1.1"`, &upstreams{}},
+	{tagFormat, `- 0.5 0 "502" -`, &tagged{}},
+	{`$rt $scheme`, `0.5 http`, &tagged{}},
+	{`$status`, `200`, &struct {
+		Status int `ngx:"status,unknown"`
+	}{}},
+	{`$status`, `200`, &struct {
+		Rest string `ngx:",rest"`
+	}{}},
+}
+
+func TestTypedCodec(t *testing.T) {
+	for _, tc := range positiveTyped {
+		ngx, err := Compile(tc.Fmt)
+		if err != nil {
+			t.Fatalf("failed to Compile() format %q: %v", tc.Fmt, err)
+		}
+
+		got := reflect.New(reflect.TypeOf(tc.Expected).Elem()).Interface()
+		if err := ngx.UnmarshalFromString(tc.Data, got); err != nil {
+			t.Fatalf("failed to UnmarshalFromString() data %q: %v", tc.Data, err)
+		}
+		if !reflect.DeepEqual(got, tc.Expected) {
+			t.Fatalf("corrupted data in UnmarshalFromString(): expecting %+v, got %+v", tc.Expected, got)
+		}
+
+		marshaled, err := ngx.MarshalToString(got)
+		if err != nil {
+			t.Fatalf("failed to MarshalToString() data %+v: %v", got, err)
+		}
+		if marshaled != tc.Marshaled {
+			t.Fatalf("corrupted data in MarshalToString(): expecting %q, got %q", tc.Marshaled, marshaled)
+		}
+	}
+
+	for _, tc := range negativeTyped {
+		ngx, err := Compile(tc.Fmt)
+		if err != nil {
+			t.Fatalf("failed to Compile() format %q: %v", tc.Fmt, err)
+		}
+		if err := ngx.UnmarshalFromString(tc.Data, tc.Type); err == nil {
+			t.Fatalf("expecting error on %q", tc.Data)
+		}
+	}
+}
+
+func TestSliceCodec(t *testing.T) {
+	ngx, err := Compile(`$remote_addr [$status] "$request"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marshaled, err := ngx.MarshalToString([]string{"127.0.0.1", "200"})
+	if err != nil {
+		t.Fatalf("failed to MarshalToString() slice: %v", err)
+	}
+	if expected := `127.0.0.1 [200] "-"`; marshaled != expected {
+		t.Fatalf("corrupted data in MarshalToString(): expecting %q, got %q", expected, marshaled)
+	}
+
+	buf := make([]string, 0, 8)
+	got := buf
+	if err := ngx.UnmarshalFromString(`::1 [404] "GET / HTTP/1.1"`, &got); err != nil {
+		t.Fatalf("failed to UnmarshalFromString() into slice: %v", err)
+	}
+	if len(got) != 3 || &got[0] != &buf[:1][0] || got[2] != "GET / HTTP/1.1" {
+		t.Fatalf("expecting the slice to be reused, got %q", got)
+	}
+}
+
+func TestUpstreamFlat(t *testing.T) {
+	ngx, err := Compile(upsFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		Data     string
+		Expected error
+	}{
+		{upsData, ErrUpstreamGroups},
+		{`10.0.0.1:80, 10.0.0.2:80 502, - 0.001, - "GET / HTTP/1.1"`, ErrUpstreamNil},
+	} {
+		var got upstreamsFlat
+		if err := ngx.UnmarshalFromString(tc.Data, &got); err == nil || !strings.Contains(err.Error(), tc.Expected.Error()) {
+			t.Fatalf("expecting %v on %q, got %v", tc.Expected, tc.Data, err)
+		}
+	}
+}
+
+func TestBytesCodecReuse(t *testing.T) {
+	ngx, err := Compile(`$status "$request"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v struct {
+		Status  int    `ngx:"status"`
+		Request []byte `ngx:"request"`
+	}
+	var saved [][]byte
+	for _, line := range []string{`200 "first"`, `404 "second"`} {
+		if err := ngx.UnmarshalFromString(line, &v); err != nil {
+			t.Fatalf("failed to UnmarshalFromString() data %q: %v", line, err)
+		}
+		saved = append(saved, v.Request)
+	}
+	if string(saved[0]) != "first" || string(saved[1]) != "second" {
+		t.Fatalf("expecting the values of each line to be kept, got %q", saved)
+	}
+}
+
+func TestBytesCodecEncode(t *testing.T) {
+	ngx, err := Compile(`$status "$request"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := struct {
+		Status  int    `ngx:"status"`
+		Request []byte `ngx:"request"`
+	}{200, []byte(`GET /"a" HTTP/1.1`)}
+	marshaled, err := ngx.MarshalToString(&v)
+	if err != nil {
+		t.Fatalf("failed to MarshalToString() data %+v: %v", v, err)
+	}
+	if expected := `200 "GET /\"a\" HTTP/1.1"`; marshaled != expected {
+		t.Fatalf("corrupted data in MarshalToString(): expecting %q, got %q", expected, marshaled)
+	}
+}
+
+func TestMapCodecNil(t *testing.T) {
+	ngx, err := Compile(`$remote_addr $status $upstream_status`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		Value    interface{}
+		Expected string
+	}{
+		{map[string]int{"status": 200}, `- 200 -`},
+		{map[string]*int{"status": nil, "upstream_status": &status502}, `- - 502`},
+		{map[string]sql.NullInt64{"status": {}}, `- - -`},
+	} {
+		marshaled, err := ngx.MarshalToString(tc.Value)
+		if err != nil {
+			t.Fatalf("failed to MarshalToString() map %v: %v", tc.Value, err)
+		}
+		if marshaled != tc.Expected {
+			t.Fatalf("corrupted data in MarshalToString(): expecting %q, got %q", tc.Expected, marshaled)
+		}
+	}
+
+	got := map[string]*int{"status": new(int)}
+	if err := ngx.UnmarshalFromString(`- - 502`, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["status"] != nil || got["upstream_status"] == nil || *got["upstream_status"] != 502 {
+		t.Fatalf("corrupted data in UnmarshalFromString(): got %v", got)
+	}
+}
+
+func TestMapCodecNested(t *testing.T) {
+	ngx, err := Compile(nestFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := `{"status":200,"cookie":"a=1","ua":"curl","up":"10.0.0.1:80"}`
+	got := make(map[string]interface{})
+	if err := ngx.UnmarshalFromString(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"status": 200,
+		"header": map[string]interface{}{"cookie": "a=1", "user_agent": "curl"},
+		"up":     map[string]interface{}{"addr": "10.0.0.1:80"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("corrupted data in UnmarshalFromString(): expecting %v, got %v", expected, got)
+	}
+
+	marshaled, err := ngx.MarshalToString(got)
+	if err != nil {
+		t.Fatalf("failed to MarshalToString() map %v: %v", got, err)
+	}
+	if marshaled != data {
+		t.Fatalf("corrupted data in MarshalToString(): expecting %q, got %q", data, marshaled)
+	}
+
+	delete(expected, "up")
+	if marshaled, _ = ngx.MarshalToString(expected); marshaled != `{"status":200,"cookie":"a=1","ua":"curl","up":"null"}` {
+		t.Fatalf("corrupted data in MarshalToString(): got %q", marshaled)
+	}
+}
+
+func TestGeneratedHook(t *testing.T) {
+	for _, tc := range []struct {
+		Fmt      string
+		Opts     Options
+		Expected generatedLine
+	}{
+		{genLineFormat, Options{}, generatedLine{line: "200 612"}},
+		{`${status} $body_bytes_sent`, Options{}, generatedLine{Status: 200}},
+		{genLineFormat, Options{Lenient: true}, generatedLine{Status: 200}},
+	} {
+		ngx, err := Compile(tc.Fmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v generatedLine
+		if err := ngx.WithOptions(tc.Opts).UnmarshalFromString("200 612", &v); err != nil {
+			t.Fatalf("unexpected error decoding with %q: %s", tc.Fmt, err)
+		}
+		if v != tc.Expected {
+			t.Fatalf("unexpected value decoding with %q %+v: expecting %+v, got %+v", tc.Fmt, tc.Opts, tc.Expected, v)
+		}
+	}
+}
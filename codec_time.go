@@ -0,0 +1,165 @@
+package ngx
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// timeLayouts maps time variables to the layout nginx prints them with.
+// $msec is not covered by a layout, see msecCodec.
+var timeLayouts = map[string]string{
+	"time_local":   "02/Jan/2006:15:04:05 -0700",
+	"time_iso8601": "2006-01-02T15:04:05-07:00",
+	"time":         "2006/01/02 15:04:05", // error log
+}
+
+type timeCodec struct {
+	esc    Esc
+	layout string
+}
+
+func (d *timeCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	v := (*time.Time)(ptr)
+	if v.IsZero() {
+		text.WriteString(d.esc.Nil())
+		return nil
+	}
+	text.WriteString(v.Format(d.layout))
+	return nil
+}
+
+func (d *timeCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*time.Time)(ptr) = time.Time{}
+		return nil
+	}
+	v, err := time.ParseInLocation(d.layout, text.String(), time.Local)
+	if err != nil {
+		if v, err = time.Parse(time.RFC3339Nano, text.String()); err != nil {
+			return fmt.Errorf("expected time in layout %q, got %q", d.layout, text.String())
+		}
+	}
+	*(*time.Time)(ptr) = v
+	return nil
+}
+
+// msecCodec handles $msec, the number of seconds since the epoch with a
+// milliseconds resolution, e.g. 1136239445.123.
+type msecCodec struct {
+	esc Esc
+}
+
+func (d *msecCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	v := (*time.Time)(ptr)
+	if v.IsZero() {
+		text.WriteString(d.esc.Nil())
+		return nil
+	}
+	text.WriteString(formatSeconds(v.Unix(), int64(v.Nanosecond())))
+	return nil
+}
+
+func (d *msecCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*time.Time)(ptr) = time.Time{}
+		return nil
+	}
+	sec, nsec, err := parseSeconds(text.String())
+	if err != nil {
+		return err
+	}
+	*(*time.Time)(ptr) = time.Unix(sec, nsec)
+	return nil
+}
+
+// durationCodec handles durations printed as seconds with a milliseconds
+// resolution, e.g. $request_time or $upstream_response_time.
+type durationCodec struct {
+	esc Esc
+}
+
+func (d *durationCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	v := *(*time.Duration)(ptr)
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	text.WriteString(sign)
+	text.WriteString(formatSeconds(int64(v/time.Second), int64(v%time.Second)))
+	return nil
+}
+
+func (d *durationCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	if text.Len() == 0 || text.String() == d.esc.Nil() {
+		*(*time.Duration)(ptr) = 0
+		return nil
+	}
+	str, neg := text.String(), false
+	if str[0] == '-' {
+		str, neg = str[1:], true
+	}
+	sec, nsec, err := parseSeconds(str)
+	if err != nil {
+		return err
+	}
+	v := time.Duration(sec)*time.Second + time.Duration(nsec)
+	if neg {
+		v = -v
+	}
+	*(*time.Duration)(ptr) = v
+	return nil
+}
+
+// parseSeconds parses a non-negative decimal number of seconds without loss of
+// precision, up to nanoseconds.
+func parseSeconds(str string) (sec, nsec int64, err error) {
+	s, frac := str, ""
+	for i := 0; i < len(s); i++ {
+		if s[i] == '.' {
+			s, frac = s[:i], s[i+1:]
+			break
+		}
+	}
+	if len(s) <= 0 || len(frac) > 9 {
+		return 0, 0, fmt.Errorf("expected seconds, got %q", str)
+	}
+	if sec, err = strconv.ParseInt(s, 10, 64); err != nil || sec < 0 {
+		return 0, 0, fmt.Errorf("expected seconds, got %q", str)
+	}
+	for i := 0; i < 9; i++ {
+		nsec *= 10
+		if i < len(frac) {
+			if frac[i] < '0' || frac[i] > '9' {
+				return 0, 0, fmt.Errorf("expected seconds, got %q", str)
+			}
+			nsec += int64(frac[i] - '0')
+		}
+	}
+	return sec, nsec, nil
+}
+
+// formatSeconds prints seconds with a milliseconds resolution, adding digits
+// only when nsec is not a whole number of milliseconds.
+func formatSeconds(sec, nsec int64) string {
+	buf := make([]byte, 0, 32)
+	buf = strconv.AppendInt(buf, sec, 10)
+	buf = append(buf, '.')
+	digits := 3
+	for nsec%pow10[9-digits] != 0 {
+		digits++
+	}
+	for i := 0; i < digits; i++ {
+		buf = append(buf, byte('0'+nsec/pow10[8-i]%10))
+	}
+	return string(buf)
+}
+
+var pow10 = [...]int64{1, 10, 100, 1000, 10000, 100000, 1000000, 10000000, 100000000}
@@ -0,0 +1,171 @@
+package ngx
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"unsafe"
+
+	"github.com/modern-go/reflect2"
+)
+
+// Separators of the values of $upstream_* variables: servers tried in turn
+// are separated by ", ", and groups of servers, after an internal redirect,
+// by " : ".
+const (
+	upstreamSep      = ", "
+	upstreamGroupSep = " : "
+)
+
+var upstreamSeps = []string{upstreamSep, upstreamGroupSep}
+
+// Errors decoding values of $upstream_* variables into lists that could not
+// render them back exactly.
+var (
+	ErrUpstreamGroups = errors.New("Upstream list has groups, which need a [][]T")
+	ErrUpstreamNil    = errors.New("Upstream list has a \"-\" entry, which needs a []*T")
+)
+
+// isUpstreamVar reports whether name is an $upstream_* variable, whose value
+// may be a list.
+func isUpstreamVar(name string) bool {
+	return strings.HasPrefix(name, "upstream_")
+}
+
+// isUpstreamList reports whether a value of typ bound to an $upstream_*
+// variable holds a list of values, i.e. typ is a slice but not a []byte.
+func isUpstreamList(typ reflect2.Type) bool {
+	return typ.Kind() == reflect.Slice && typ.(*reflect2.UnsafeSliceType).Elem().Kind() != reflect.Uint8
+}
+
+// codecOfUpstream returns the codec of a list bound to the $upstream_*
+// variable name. A []T holds the values of a single group, and a [][]T holds
+// a []T per group, so that Marshal renders them back exactly: decoding groups
+// into a []T fails with ErrUpstreamGroups. A "-" entry decodes to a nil *T,
+// or to "-" for strings, and fails with ErrUpstreamNil for other types.
+func codecOfUpstream(ngx *NGX, name string, typ *reflect2.UnsafeSliceType) (Codec, error) {
+	elem := typ.Elem()
+	if !isUpstreamList(elem) {
+		d, err := newListCodec(ngx, name, typ, ngx.esc.Nil(), nil, upstreamSep)
+		if err != nil {
+			return nil, err
+		}
+		d.flat = true
+		return d, nil
+	}
+	group, err := newListCodec(ngx, name, elem.(*reflect2.UnsafeSliceType), "-", nil, upstreamSep)
+	if err != nil {
+		return nil, err
+	}
+	return newListCodec(ngx, name, typ, ngx.esc.Nil(), group, upstreamGroupSep)
+}
+
+func newListCodec(ngx *NGX, name string, typ *reflect2.UnsafeSliceType, nilMark string, group *listCodec, seps ...string) (*listCodec, error) {
+	elem := typ.Elem()
+	d := &listCodec{
+		nil:       nilMark,
+		seps:      seps,
+		sliceType: typ,
+		elemType:  elem,
+		nilable:   elem.Kind() == reflect.Ptr,
+		verbatim:  elem.Kind() == reflect.String,
+		zero:      elem.UnsafeNew(),
+		group:     group != nil,
+	}
+	if group != nil {
+		d.elemCodec = group
+		return d, nil
+	}
+	codec, err := codecOfVar(ngx, name, elem)
+	if err != nil {
+		return nil, err
+	}
+	d.elemCodec = codec
+	return d, nil
+}
+
+// listCodec handles a list of values, or of groups of values.
+type listCodec struct {
+	nil  string   // written for an empty list
+	seps []string // the first one is used by Encode
+
+	sliceType *reflect2.UnsafeSliceType
+	elemType  reflect2.Type
+	elemCodec Codec
+	nilable   bool // "-" entries are nil pointers
+	verbatim  bool // "-" entries are decoded as is
+	zero      unsafe.Pointer
+	group     bool // elements are groups, not values
+	flat      bool // values of a single group
+}
+
+func (d *listCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	length := d.sliceType.UnsafeLengthOf(ptr)
+	if length == 0 {
+		text.WriteString(d.nil)
+		return nil
+	}
+	for i := 0; i < length; i++ {
+		if i > 0 {
+			text.WriteString(d.seps[0])
+		}
+		elem := d.sliceType.UnsafeGetIndex(ptr, i)
+		if d.nilable && *(*unsafe.Pointer)(elem) == nil {
+			text.WriteString("-")
+			continue
+		}
+		if err := d.elemCodec.Encode(elem, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *listCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	data := text.Bytes()
+	if len(data) == 0 || string(data) == d.nil {
+		d.sliceType.UnsafeSetNil(ptr)
+		return nil
+	}
+	if d.flat && bytes.Contains(data, []byte(upstreamGroupSep)) {
+		return ErrUpstreamGroups
+	}
+	d.sliceType.UnsafeGrow(ptr, 0)
+	for i := 0; ; i++ {
+		n, skip := d.index(data)
+		d.sliceType.UnsafeGrow(ptr, i+1)
+		elem := d.sliceType.UnsafeGetIndex(ptr, i)
+		d.elemType.UnsafeSet(elem, d.zero)
+		entry := data[:n]
+		if !d.group && len(entry) == 1 && entry[0] == '-' && !d.verbatim {
+			if !d.nilable {
+				return ErrUpstreamNil
+			}
+		} else if err := d.elemCodec.Decode(elem, NewBytesReader(entry)); err != nil {
+			return err
+		}
+		if skip == 0 {
+			return nil
+		}
+		data = data[n+skip:]
+	}
+}
+
+// index returns the index of the first separator in data and its length, or
+// len(data) and 0 if there is none.
+func (d *listCodec) index(data []byte) (int, int) {
+	return indexSep(data, d.seps)
+}
+
+// indexSep returns the index of the first of seps in data and its length, or
+// len(data) and 0 if there is none.
+func indexSep(data []byte, seps []string) (int, int) {
+	n, skip := len(data), 0
+	for _, sep := range seps {
+		if off := bytes.Index(data[:n], []byte(sep)); off >= 0 {
+			n, skip = off, len(sep)
+		}
+	}
+	return n, skip
+}
@@ -23,11 +23,24 @@ var (
 type baseOp struct {
 	Type  int
 	Extra []byte
+	Var   *Variable // catalog entry of a variable, nil if unknown
+}
+
+// compileError returns a *SyntaxError at byte p of logfmt, wrapping err.
+func compileError(logfmt string, p, op int, err error, msg string) error {
+	return &SyntaxError{
+		Msg:     msg,
+		Op:      op,
+		Column:  p + 1,
+		Snippet: snippet([]byte(logfmt), p),
+		Err:     err,
+	}
 }
 
 func Compile(logfmt string) (*NGX, error) {
 	q, p := 0, 0
 	ngx := &NGX{
+		src:       logfmt,
 		ops:       make([]baseOp, 0, 8),
 		supported: make(map[string]int),
 	}
@@ -44,7 +57,7 @@ func Compile(logfmt string) (*NGX, error) {
 			p += 4
 			ngx.esc = EscNone
 		} else {
-			return nil, ErrUnknownLogFormatEscaping
+			return nil, compileError(logfmt, p, 0, ErrUnknownLogFormatEscaping, "")
 		}
 	skip_semi:
 		for p < len(logfmt) {
@@ -55,19 +68,21 @@ func Compile(logfmt string) (*NGX, error) {
 				p++
 				break skip_semi
 			default:
-				return nil, fmt.Errorf("expecting ';' after escape=%s", ngx.esc)
+				return nil, compileError(logfmt, p, 0, ErrInvalidLogFormat, fmt.Sprintf("expecting ';' after escape=%s", ngx.esc))
 			}
 		}
 	}
 
 	last := bytes.NewBuffer(nil)
+	cols := make(map[int]int) // where each variable starts in logfmt
 
 	for q = p; p < len(logfmt); {
 		if logfmt[p] == '$' {
+			start := p
 			p++
 			bracket := false
 			if p >= len(logfmt) {
-				return nil, ErrInvalidLogFormat
+				return nil, compileError(logfmt, start, len(ngx.ops), ErrInvalidLogFormat, "")
 			}
 			if logfmt[p] == '$' {
 				last.WriteByte('$')
@@ -78,7 +93,7 @@ func Compile(logfmt string) (*NGX, error) {
 				bracket = true
 				p++
 				if p >= len(logfmt) {
-					return nil, ErrInvalidLogFormat
+					return nil, compileError(logfmt, start, len(ngx.ops), ErrInvalidLogFormat, "")
 				}
 			}
 			if last.Len() > 0 {
@@ -107,41 +122,38 @@ func Compile(logfmt string) (*NGX, error) {
 				}
 			}
 			if bracket {
-				return nil, fmt.Errorf("the closing bracket of variable %q is missing", logfmt[q:p])
+				return nil, compileError(logfmt, start, len(ngx.ops), ErrInvalidLogFormat, fmt.Sprintf("the closing bracket of variable %q is missing", logfmt[q:p]))
 			}
 			// if p-q <= 0 {
 			// 	return nil, ErrInvalidLogFormat
 			// }
 			varname := logfmt[q:p]
 			if len(varname) <= 0 || varname == "}" {
-				return nil, ErrInvalidLogFormat
+				return nil, compileError(logfmt, start, len(ngx.ops), ErrInvalidLogFormat, "")
 			}
 			if varname[len(varname)-1] == '}' {
 				varname = varname[:len(varname)-1]
 			}
 			varlen := len(varname)
 			if varlen <= 0 {
-				return nil, ErrInvalidLogFormat
+				return nil, compileError(logfmt, start, len(ngx.ops), ErrInvalidLogFormat, "")
 			}
 			if varname[0] == '.' {
-				return nil, fmt.Errorf("variable %q cannot start with '.'", varname)
+				return nil, compileError(logfmt, start, len(ngx.ops), ErrInvalidLogFormat, fmt.Sprintf("variable %q cannot start with '.'", varname))
 			}
 			if varname[varlen-1] == '.' {
-				return nil, fmt.Errorf("variable %q cannot end with '.'", varname)
+				return nil, compileError(logfmt, start, len(ngx.ops), ErrInvalidLogFormat, fmt.Sprintf("variable %q cannot end with '.'", varname))
 			}
 			if strings.Contains(varname, "..") {
-				return nil, fmt.Errorf("variable %q cannot have consecutive dots", varname)
-			}
-			pos := len(ngx.ops)
-			if pos > 0 && ngx.ops[pos-1].Type == ngxVariable {
-				// skip
-			} else {
-				ngx.supported[varname] = pos
-				ngx.ops = append(ngx.ops, baseOp{
-					Type:  ngxVariable,
-					Extra: []byte(varname),
-				})
+				return nil, compileError(logfmt, start, len(ngx.ops), ErrInvalidLogFormat, fmt.Sprintf("variable %q cannot have consecutive dots", varname))
 			}
+			cols[len(ngx.ops)] = start
+			ngx.supported[varname] = len(ngx.ops)
+			ngx.ops = append(ngx.ops, baseOp{
+				Type:  ngxVariable,
+				Extra: []byte(varname),
+				Var:   LookupVariable(varname),
+			})
 			q = p
 		} else {
 			next := strings.IndexByte(logfmt[q:], '$')
@@ -170,5 +182,11 @@ func Compile(logfmt string) (*NGX, error) {
 		})
 	}
 
+	for i := 0; i+1 < len(ngx.ops); i++ {
+		if ngx.ops[i].Type == ngxVariable && ngx.ops[i+1].Type == ngxVariable && !adjacentSplittable(ngx.ops, i) {
+			return nil, compileError(logfmt, cols[i], i, ErrInvalidLogFormat, fmt.Sprintf("cannot tell $%s and $%s apart: separate them with a literal", ngx.ops[i].Extra, ngx.ops[i+1].Extra))
+		}
+	}
+
 	return ngx, nil
 }
@@ -14,6 +14,8 @@ var positiveFormats = []string{
 	`escape=default           		; $request "$request_body" "$header_cookie"`,
 	`escape=json;$request "$request_body""$header.cookie"`,
 	`escape=json;$request "$request_body""$header.cookie"$$`,
+	`$scheme$host$status $body_bytes_sent`,
+	`$remote_addr$scheme "$request" $request_time$upstream_response_time`,
 }
 
 var negativeFormats = []string{
@@ -26,6 +28,9 @@ var negativeFormats = []string{
 	`escape=json;$request "$request_body""$header..cookie"`,
 	`escape=json;$request "$request_body""$header....cookie"`,
 	`escape=json;$request "$request_body""$header.cookie"$`,
+	`$request$request_body`,
+	`$host$server_name "$request"`,
+	`$status $host$request_uri`,
 }
 
 func TestCompile(t *testing.T) {
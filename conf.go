@@ -0,0 +1,330 @@
+package ngx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const maxIncludeDepth = 32
+
+var ErrInvalidConfig = errors.New("Invalid nginx configuration")
+
+// Config holds the log formats defined in a nginx configuration, and the
+// format each access log is written in.
+type Config struct {
+	formats    map[string]*NGX
+	sources    map[string]string
+	accessLogs map[string]string
+}
+
+// LoadConfig reads a nginx configuration file, following include directives,
+// and compiles every log_format it defines. Relative include paths are
+// resolved against the directory of filename, like nginx does with its prefix.
+//
+// The predefined "combined" format is always available. Formats defined in
+// the http and stream modules share the same namespace.
+func LoadConfig(filename string) (*Config, error) {
+	p := &confParser{
+		prefix: filepath.Dir(filename),
+		config: &Config{
+			formats:    make(map[string]*NGX),
+			sources:    make(map[string]string),
+			accessLogs: make(map[string]string),
+		},
+		accessLogs: make(map[string]confPos),
+	}
+	p.config.sources["combined"] = CombinedFmt
+	p.config.formats["combined"] = ngx
+
+	if err := p.parseFile(filename, 0); err != nil {
+		return nil, err
+	}
+
+	for path, pos := range p.accessLogs {
+		name := p.config.accessLogs[path]
+		if _, ok := p.config.formats[name]; !ok {
+			return nil, fmt.Errorf("%w: %s: unknown log format %q", ErrInvalidConfig, pos, name)
+		}
+	}
+	return p.config, nil
+}
+
+// Format returns the compiled log_format with the given name.
+func (c *Config) Format(name string) (*NGX, bool) {
+	ngx, ok := c.formats[name]
+	return ngx, ok
+}
+
+// FormatString returns the source of the log_format with the given name, as
+// accepted by Compile.
+func (c *Config) FormatString(name string) (string, bool) {
+	src, ok := c.sources[name]
+	return src, ok
+}
+
+// Formats returns all log formats by name.
+func (c *Config) Formats() map[string]*NGX {
+	formats := make(map[string]*NGX, len(c.formats))
+	for name, ngx := range c.formats {
+		formats[name] = ngx
+	}
+	return formats
+}
+
+// AccessLog returns the format the access log at path is written in. path
+// must be spelled as in the access_log directive.
+func (c *Config) AccessLog(path string) (*NGX, bool) {
+	name, ok := c.accessLogs[path]
+	if !ok {
+		return nil, false
+	}
+	return c.Format(name)
+}
+
+// AccessLogs returns the format name of every access log by path.
+func (c *Config) AccessLogs() map[string]string {
+	logs := make(map[string]string, len(c.accessLogs))
+	for path, name := range c.accessLogs {
+		logs[path] = name
+	}
+	return logs
+}
+
+type confPos struct {
+	file string
+	line int
+}
+
+func (pos confPos) String() string {
+	return fmt.Sprintf("%s:%d", pos.file, pos.line)
+}
+
+type confParser struct {
+	prefix     string
+	config     *Config
+	accessLogs map[string]confPos
+}
+
+func (p *confParser) parseFile(filename string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%w: too many nested includes in %s", ErrInvalidConfig, filename)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	lex := &confLexer{data: data, line: 1}
+	var (
+		args   []string
+		pos    confPos
+		blocks int
+	)
+	for {
+		line := lex.line
+		tok, special, err := lex.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s:%d: %v", ErrInvalidConfig, filename, lex.line, err)
+		}
+		if len(args) == 0 {
+			pos = confPos{filename, line}
+		}
+
+		switch {
+		case special && tok == ";":
+			if len(args) == 0 {
+				return fmt.Errorf("%w: %s: unexpected \";\"", ErrInvalidConfig, pos)
+			}
+			if err := p.directive(args, pos, depth); err != nil {
+				return err
+			}
+			args = args[:0]
+		case special && tok == "{":
+			if len(args) == 0 {
+				return fmt.Errorf("%w: %s: unexpected \"{\"", ErrInvalidConfig, pos)
+			}
+			blocks++
+			args = args[:0]
+		case special && tok == "}":
+			if len(args) > 0 || blocks <= 0 {
+				return fmt.Errorf("%w: %s: unexpected \"}\"", ErrInvalidConfig, pos)
+			}
+			blocks--
+		default:
+			args = append(args, tok)
+		}
+	}
+
+	if len(args) > 0 || blocks > 0 {
+		return fmt.Errorf("%w: %s: unexpected end of file", ErrInvalidConfig, filename)
+	}
+	return nil
+}
+
+func (p *confParser) directive(args []string, pos confPos, depth int) error {
+	switch args[0] {
+	case "include":
+		if len(args) != 2 {
+			return fmt.Errorf("%w: %s: invalid number of arguments in \"include\"", ErrInvalidConfig, pos)
+		}
+		pattern := args[1]
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(p.prefix, pattern)
+		}
+		files := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			if files, err = filepath.Glob(pattern); err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrInvalidConfig, pos, err)
+			}
+		}
+		for _, file := range files {
+			if err := p.parseFile(file, depth+1); err != nil {
+				return err
+			}
+		}
+
+	case "log_format":
+		if len(args) < 3 {
+			return fmt.Errorf("%w: %s: invalid number of arguments in \"log_format\"", ErrInvalidConfig, pos)
+		}
+		name, parts := args[1], args[2:]
+		if _, ok := p.config.sources[name]; ok {
+			return fmt.Errorf("%w: %s: duplicate \"log_format\" name %q", ErrInvalidConfig, pos, name)
+		}
+		esc := "escape=default;"
+		if strings.HasPrefix(parts[0], "escape=") {
+			esc, parts = parts[0]+";", parts[1:]
+		}
+		src := esc + strings.Join(parts, "")
+		compiled, err := Compile(src)
+		if err != nil {
+			return fmt.Errorf("%s: log_format %q: %w", pos, name, err)
+		}
+		p.config.sources[name] = src
+		p.config.formats[name] = compiled
+
+	case "access_log":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: %s: invalid number of arguments in \"access_log\"", ErrInvalidConfig, pos)
+		}
+		if args[1] == "off" {
+			return nil
+		}
+		name := "combined"
+		if len(args) > 2 && !strings.Contains(args[2], "=") {
+			name = args[2]
+		}
+		p.config.accessLogs[args[1]] = name
+		p.accessLogs[args[1]] = pos
+	}
+	return nil
+}
+
+// confLexer splits a nginx configuration into tokens, following the rules of
+// ngx_conf_read_token.
+type confLexer struct {
+	data []byte
+	p    int
+	line int
+}
+
+// next returns the next token. special is true for unquoted ";", "{" and "}".
+func (l *confLexer) next() (tok string, special bool, err error) {
+	for l.p < len(l.data) {
+		ch := l.data[l.p]
+		switch ch {
+		case '\n':
+			l.line++
+			fallthrough
+		case ' ', '\t', '\r':
+			l.p++
+			continue
+		case '#':
+			for l.p < len(l.data) && l.data[l.p] != '\n' {
+				l.p++
+			}
+			continue
+		case ';', '{', '}':
+			l.p++
+			return string(ch), true, nil
+		case '"', '\'':
+			return l.quoted(ch)
+		}
+		return l.word(), false, nil
+	}
+	return "", false, io.EOF
+}
+
+func (l *confLexer) quoted(quote byte) (string, bool, error) {
+	var buf []byte
+	for l.p++; l.p < len(l.data); l.p++ {
+		ch := l.data[l.p]
+		switch {
+		case ch == quote:
+			l.p++
+			return string(buf), false, nil
+		case ch == '\\' && l.p+1 < len(l.data):
+			l.p++
+			buf = appendConfEscape(buf, l.data[l.p])
+			continue
+		case ch == '\n':
+			l.line++
+		}
+		buf = append(buf, ch)
+	}
+	return "", false, errors.New("unexpected end of file, expecting closing quote")
+}
+
+func (l *confLexer) word() string {
+	var buf []byte
+	variable := false
+	for ; l.p < len(l.data); l.p++ {
+		ch := l.data[l.p]
+		switch ch {
+		case ' ', '\t', '\r', '\n', ';':
+			return string(buf)
+		case '{':
+			// "${" starts a variable, not a block
+			if len(buf) == 0 || buf[len(buf)-1] != '$' {
+				return string(buf)
+			}
+			variable = true
+		case '}':
+			if !variable {
+				return string(buf)
+			}
+			variable = false
+		case '\\':
+			if l.p+1 < len(l.data) {
+				l.p++
+				buf = appendConfEscape(buf, l.data[l.p])
+				continue
+			}
+		}
+		buf = append(buf, ch)
+	}
+	return string(buf)
+}
+
+func appendConfEscape(buf []byte, ch byte) []byte {
+	switch ch {
+	case '"', '\'', '\\':
+		return append(buf, ch)
+	case 't':
+		return append(buf, '\t')
+	case 'r':
+		return append(buf, '\r')
+	case 'n':
+		return append(buf, '\n')
+	default:
+		return append(buf, '\\', ch)
+	}
+}
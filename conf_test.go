@@ -0,0 +1,130 @@
+package ngx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testConfig = map[string]string{
+	"nginx.conf": `
+# comment with a log_format 'x';
+user nginx;
+http {
+    include conf.d/*.conf;
+    log_format main '$remote_addr - $remote_user [$time_local] "$request" '
+                    '$status $body_bytes_sent "$http_referer" '   # trailing comment
+                    "\"$http_user_agent\" \"${http_x_forwarded_for}\"";
+    access_log /var/log/nginx/access.log main buffer=32k;
+    server {
+        location / {
+            access_log /var/log/nginx/default.log;
+            access_log off;
+        }
+    }
+}
+stream {
+    log_format basic $remote_addr:$server_port;
+    access_log /var/log/nginx/stream.log basic if=$loggable;
+}
+`,
+	"conf.d/json.conf": `
+log_format json escape=json '{"addr":"$remote_addr","ua":"$http_user_agent"}';
+server { access_log /var/log/nginx/json.log json; }
+`,
+}
+
+func writeConfig(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "ngx-conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := writeConfig(t, testConfig)
+	defer os.RemoveAll(dir)
+
+	conf, err := LoadConfig(filepath.Join(dir, "nginx.conf"))
+	if err != nil {
+		t.Fatalf("failed to LoadConfig(): %v", err)
+	}
+
+	sources := map[string]string{
+		"combined": CombinedFmt,
+		"main":     `escape=default;$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" "${http_x_forwarded_for}"`,
+		"basic":    `escape=default;$remote_addr:$server_port`,
+		"json":     `escape=json;{"addr":"$remote_addr","ua":"$http_user_agent"}`,
+	}
+	if len(conf.Formats()) != len(sources) {
+		t.Fatalf("expecting %d formats, got %d", len(sources), len(conf.Formats()))
+	}
+	for name, src := range sources {
+		got, ok := conf.FormatString(name)
+		if !ok || got != src {
+			t.Fatalf("corrupted log_format %q: expecting %q, got %q", name, src, got)
+		}
+		if f, ok := conf.Format(name); !ok || f == nil {
+			t.Fatalf("log_format %q is not compiled", name)
+		}
+	}
+
+	logs := map[string]string{
+		"/var/log/nginx/access.log":  "main",
+		"/var/log/nginx/default.log": "combined",
+		"/var/log/nginx/stream.log":  "basic",
+		"/var/log/nginx/json.log":    "json",
+	}
+	if !reflect.DeepEqual(conf.AccessLogs(), logs) {
+		t.Fatalf("corrupted access logs: expecting %q, got %q", logs, conf.AccessLogs())
+	}
+
+	f, ok := conf.AccessLog("/var/log/nginx/json.log")
+	if !ok {
+		t.Fatal("missing access log /var/log/nginx/json.log")
+	}
+	m := make(map[string]string)
+	if err := f.UnmarshalFromString(`{"addr":"1.1.1.1","ua":"curl \"7\""}`, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["remote_addr"] != "1.1.1.1" || m["http_user_agent"] != `curl "7"` {
+		t.Fatalf("corrupted data in UnmarshalFromString(): got %q", m)
+	}
+}
+
+var negativeConfig = []string{
+	`log_format main '$remote_addr`,
+	`http { log_format main '$remote_addr';`,
+	`http { log_format main '$remote_addr'; }}`,
+	`log_format main;`,
+	`log_format main escape=xml '$remote_addr';`,
+	`log_format main '${remote_addr';`,
+	`log_format main '$remote_addr'; log_format main '$status';`,
+	`log_format combined '$remote_addr';`,
+	`access_log /var/log/access.log unknown;`,
+	`include missing.conf;`,
+	`include nginx.conf;`,
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, data := range negativeConfig {
+		dir := writeConfig(t, map[string]string{"nginx.conf": data})
+		_, err := LoadConfig(filepath.Join(dir, "nginx.conf"))
+		os.RemoveAll(dir)
+		if err == nil {
+			t.Fatalf("expecting error on %q", data)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package ngx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	defaultMaxLineSize = 1 << 20 // 1M
+	minReadBufferSize  = 0x1000
+	maxConsecutiveRead = 100
+)
+
+var ErrLineTooLong = errors.New("line too long")
+
+// lineReader splits a stream into lines, reusing its buffer between calls.
+// Lines longer than max are discarded as a whole and reported as
+// ErrLineTooLong, so that the stream can continue with the next line.
+type lineReader struct {
+	r   io.Reader
+	buf []byte
+	max int
+	err error
+
+	start int   // start of unread data in buf
+	end   int   // end of valid data in buf
+	pos   int64 // stream offset of buf[start]
+
+	lineno int   // number of the last returned line, starting from 1
+	offset int64 // stream offset of the last returned line
+}
+
+func (lr *lineReader) advance(n int) {
+	lr.start += n
+	lr.pos += int64(n)
+}
+
+// fill reads a new chunk into buf, compacting or growing it when necessary.
+func (lr *lineReader) fill() {
+	if lr.start > 0 {
+		copy(lr.buf, lr.buf[lr.start:lr.end])
+		lr.end -= lr.start
+		lr.start = 0
+	}
+
+	if lr.end >= len(lr.buf) {
+		size := 2 * len(lr.buf)
+		if size < minReadBufferSize {
+			size = minReadBufferSize
+		}
+		if size > lr.max+1 {
+			size = lr.max + 1
+		}
+		buf := make([]byte, size)
+		copy(buf, lr.buf[:lr.end])
+		lr.buf = buf
+	}
+
+	for i := 0; i < maxConsecutiveRead; i++ {
+		n, err := lr.r.Read(lr.buf[lr.end:])
+		lr.end += n
+		if err != nil {
+			lr.err = err
+			return
+		}
+		if n > 0 {
+			return
+		}
+	}
+	lr.err = io.ErrNoProgress
+}
+
+// next returns the next line without its line terminator ("\n" or "\r\n").
+// The returned slice is only valid until the next call.
+func (lr *lineReader) next() ([]byte, error) {
+	discard := false
+	lr.offset = lr.pos
+	for {
+		var line []byte
+		if i := bytes.IndexByte(lr.buf[lr.start:lr.end], '\n'); i >= 0 {
+			line = lr.buf[lr.start : lr.start+i]
+			lr.advance(i + 1)
+		} else if lr.err != nil {
+			if lr.start >= lr.end && !discard {
+				return nil, lr.err
+			}
+			line = lr.buf[lr.start:lr.end]
+			lr.advance(lr.end - lr.start)
+		} else {
+			if lr.end-lr.start > lr.max {
+				discard = true
+				lr.advance(lr.end - lr.start)
+			}
+			lr.fill()
+			continue
+		}
+
+		lr.lineno++
+		if discard || len(line) > lr.max {
+			return nil, ErrLineTooLong
+		}
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+		return line, nil
+	}
+}
+
+// detach returns a copy of line, which strings decoded from it may safely
+// refer to once the buffer line was read into is reused by the next read.
+func detach(line []byte) string {
+	return string(line)
+}
+
+// A Decoder reads and decodes log lines from an input stream.
+type Decoder struct {
+	ngx *NGX
+	lr  lineReader
+
+	line    []byte
+	lineErr error
+	ready   bool
+}
+
+// NewDecoder returns a new decoder that reads lines in ngx's format from r.
+func (ngx *NGX) NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		ngx: ngx,
+		lr: lineReader{
+			r:   r,
+			max: defaultMaxLineSize,
+		},
+	}
+}
+
+// SetMaxLineSize sets the maximum length of a line, excluding its line
+// terminator. Longer lines make Decode return ErrLineTooLong and are skipped.
+func (dec *Decoder) SetMaxLineSize(n int) {
+	if n > 0 {
+		dec.lr.max = n
+	}
+}
+
+func (dec *Decoder) peek() bool {
+	for !dec.ready {
+		line, err := dec.lr.next()
+		if err == ErrLineTooLong {
+			dec.line, dec.lineErr, dec.ready = nil, err, true
+			break
+		}
+		if err != nil {
+			return false
+		}
+		if len(line) > 0 {
+			dec.line, dec.lineErr, dec.ready = line, nil, true
+		}
+	}
+	return true
+}
+
+// More reports whether there is another line to decode.
+// Empty lines are skipped.
+func (dec *Decoder) More() bool {
+	return dec.peek()
+}
+
+// Decode decodes the next non-empty line into v.
+// It returns io.EOF when the input is exhausted.
+func (dec *Decoder) Decode(v interface{}) error {
+	if !dec.peek() {
+		return dec.lr.err
+	}
+	dec.ready = false
+	if dec.lineErr != nil {
+		return fmt.Errorf("line %d: %w", dec.lr.lineno, dec.lineErr)
+	}
+	if err := dec.ngx.UnmarshalFromString(detach(dec.line), v); err != nil {
+		return fmt.Errorf("line %d: %w", dec.lr.lineno, err)
+	}
+	return nil
+}
+
+// Line returns the number of the last decoded line, starting from 1.
+func (dec *Decoder) Line() int {
+	return dec.lr.lineno
+}
+
+// Offset returns the byte offset of the last decoded line in the input.
+func (dec *Decoder) Offset() int64 {
+	return dec.lr.offset
+}
+
+// Bytes returns the raw contents of the last decoded line. The slice is only
+// valid until the next call to More or Decode.
+func (dec *Decoder) Bytes() []byte {
+	return dec.line
+}
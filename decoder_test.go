@@ -0,0 +1,68 @@
+package ngx
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecoder(t *testing.T) {
+	ngx, err := Compile(`$remote_addr "$request" $status`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "1.1.1.1 \"GET / HTTP/1.1\" 200\r\n" +
+		"\n" +
+		"2.2.2.2 \"" + strings.Repeat("A", 64) + "\" 200\n" +
+		"3.3.3.3 \"POST /x HTTP/1.1\" 404\n" +
+		"4.4.4.4 \"GET /y HTTP/1.1\" 500"
+
+	expected := []struct {
+		Line   int
+		Offset int64
+		Err    error
+		Value  Access
+	}{
+		{1, 0, nil, Access{RemoteAddr: "1.1.1.1", Request: "GET / HTTP/1.1", Status: 200}},
+		{3, 31, ErrLineTooLong, Access{}},
+		{4, 110, nil, Access{RemoteAddr: "3.3.3.3", Request: "POST /x HTTP/1.1", Status: 404}},
+		{5, 141, nil, Access{RemoteAddr: "4.4.4.4", Request: "GET /y HTTP/1.1", Status: 500}},
+	}
+
+	readers := map[string]io.Reader{
+		"plain":   strings.NewReader(input),
+		"onebyte": iotest.OneByteReader(strings.NewReader(input)),
+		"half":    iotest.HalfReader(strings.NewReader(input)),
+	}
+
+	for name, r := range readers {
+		dec := ngx.NewDecoder(r)
+		dec.SetMaxLineSize(48)
+		for _, tc := range expected {
+			if !dec.More() {
+				t.Fatalf("%s: unexpected end of stream before line %d", name, tc.Line)
+			}
+			var got Access
+			err := dec.Decode(&got)
+			if !errors.Is(err, tc.Err) {
+				t.Fatalf("%s: line %d: expecting error %v, got %v", name, tc.Line, tc.Err, err)
+			}
+			if dec.Line() != tc.Line || dec.Offset() != tc.Offset {
+				t.Fatalf("%s: expecting line %d at offset %d, got line %d at offset %d", name, tc.Line, tc.Offset, dec.Line(), dec.Offset())
+			}
+			if !reflect.DeepEqual(got, tc.Value) {
+				t.Fatalf("%s: line %d: expecting %q, got %q", name, tc.Line, tc.Value, got)
+			}
+		}
+		if dec.More() {
+			t.Fatalf("%s: expecting end of stream", name)
+		}
+		if err := dec.Decode(new(Access)); err != io.EOF {
+			t.Fatalf("%s: expecting io.EOF, got %v", name, err)
+		}
+	}
+}
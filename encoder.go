@@ -0,0 +1,46 @@
+package ngx
+
+import (
+	"bufio"
+	"io"
+)
+
+const defaultEncoderBufferSize = 0x10000 // 64k
+
+// An Encoder writes log lines to an output stream.
+type Encoder struct {
+	ngx *NGX
+	w   *bufio.Writer
+}
+
+// NewEncoder returns a new encoder that writes lines in ngx's format to w.
+// Output is buffered, call Flush once all lines have been written.
+func (ngx *NGX) NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		ngx: ngx,
+		w:   bufio.NewWriterSize(w, defaultEncoderBufferSize),
+	}
+}
+
+// Encode writes the encoding of v followed by a newline. Nothing is written
+// if v cannot be encoded.
+func (enc *Encoder) Encode(v interface{}) error {
+	if len(enc.ngx.ops) <= 0 {
+		return nil
+	}
+
+	w := AcquireWriter()
+	if err := enc.ngx.encode(v, w); err != nil {
+		ReleaseWriter(w)
+		return err
+	}
+	w.WriteByte('\n')
+	_, err := enc.w.Write(w.Bytes())
+	ReleaseWriter(w)
+	return err
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (enc *Encoder) Flush() error {
+	return enc.w.Flush()
+}
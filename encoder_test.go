@@ -0,0 +1,92 @@
+package ngx
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncoder(t *testing.T) {
+	ngx, err := Compile(CombinedFmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := []Access{
+		{RemoteAddr: "1.1.1.1", RemoteUser: "-", TimeLocal: "01/Jan/2020:00:00:00 +0000", Request: "GET / HTTP/1.1", Status: 200, BodyBytesSent: 12, HTTPReferer: "-", HTTPUserAgent: "curl/7.68.0"},
+		{RemoteAddr: "2.2.2.2", RemoteUser: "bob", TimeLocal: "01/Jan/2020:00:00:01 +0000", Request: "POST /\"x\" HTTP/1.1", Status: 404, BodyBytesSent: 0, HTTPReferer: "-", HTTPUserAgent: "Mozilla/5.0"},
+	}
+
+	var out bytes.Buffer
+	enc := ngx.NewEncoder(&out)
+	for i := range values {
+		if err := enc.Encode(&values[i]); err != nil {
+			t.Fatalf("failed to Encode() %q: %v", values[i], err)
+		}
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expecting output to be buffered until Flush(), got %q", out.String())
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected []byte
+	for _, v := range values {
+		if expected, err = ngx.AppendMarshal(expected, v); err != nil {
+			t.Fatalf("failed to AppendMarshal() %q: %v", v, err)
+		}
+		expected = append(expected, '\n')
+	}
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Fatalf("corrupted data in Encode(): expecting %q, got %q", expected, out.Bytes())
+	}
+
+	dec := ngx.NewDecoder(&out)
+	for _, v := range values {
+		var got Access
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("failed to Decode() line %d: %v", dec.Line(), err)
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Fatalf("corrupted data in Decode(): expecting %q, got %q", v, got)
+		}
+	}
+	if dec.More() {
+		t.Fatal("expecting end of stream")
+	}
+}
+
+func TestAppendMarshal(t *testing.T) {
+	ngx, err := Compile(`$remote_addr $status`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := make([]byte, 0, 64)
+	dst = append(dst, "prefix "...)
+	got, err := ngx.AppendMarshal(dst, &Access{RemoteAddr: "1.1.1.1", Status: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "prefix 1.1.1.1 200" {
+		t.Fatalf("corrupted data in AppendMarshal(): got %q", got)
+	}
+	if &got[0] != &dst[0] {
+		t.Fatal("expecting AppendMarshal() to reuse the capacity of dst")
+	}
+
+	// the codec cached for *Access must be usable for decoding as well
+	var a Access
+	if err := ngx.UnmarshalFromString("2.2.2.2 404", &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.RemoteAddr != "2.2.2.2" || a.Status != 404 {
+		t.Fatalf("corrupted data in UnmarshalFromString(): got %q", a)
+	}
+
+	var nilAccess *Access
+	if got, err = ngx.AppendMarshal(nil, nilAccess); err != nil || string(got) != "-" {
+		t.Fatalf("expecting nil marker for nil pointer, got %q, %v", got, err)
+	}
+}
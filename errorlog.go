@@ -0,0 +1,423 @@
+package ngx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+var (
+	ErrInvalidErrorLog   = errors.New("Invalid error log entry")
+	ErrUnknownErrorLevel = errors.New("Unknown error log level")
+)
+
+// ErrorLogLevels lists the severity levels of the error log, from the least
+// to the most severe.
+var ErrorLogLevels = []string{"debug", "info", "notice", "warn", "error", "crit", "alert", "emerg"}
+
+// ErrorLogVariables lists the names an error log entry binds to, in the order
+// they appear in an entry. Any other "key: value" pair of the trailing context
+// is only available when decoding into a map.
+var ErrorLogVariables = []string{"time", "level", "pid", "tid", "connection", "message", "client", "server", "request", "subrequest", "upstream", "host", "referrer"}
+
+// ErrorLog is an entry of the nginx error log, e.g.
+//
+//	2024/01/02 15:04:05 [error] 123#456: *789 message, client: 1.2.3.4, server: x, request: "GET / HTTP/1.1", host: "x"
+type ErrorLog struct {
+	Time       string `ngx:"time"`
+	Level      string `ngx:"level"`
+	PID        int    `ngx:"pid"`
+	TID        int    `ngx:"tid"`
+	Connection int64  `ngx:"connection"`
+	Message    string `ngx:"message"`
+	Client     string `ngx:"client"`
+	Server     string `ngx:"server"`
+	Request    string `ngx:"request"`
+	Subrequest string `ngx:"subrequest"`
+	Upstream   string `ngx:"upstream"`
+	Host       string `ngx:"host"`
+	Referrer   string `ngx:"referrer"`
+}
+
+// errorLogFormat only serves to bind struct fields and map keys to the names
+// of ErrorLogVariables, entries are never matched against its ops.
+var errorLogFormat = newErrorLogFormat()
+
+func newErrorLogFormat() *NGX {
+	ngx := &NGX{
+		ops:       make([]baseOp, 0, len(ErrorLogVariables)),
+		esc:       EscNone,
+		supported: make(map[string]int),
+	}
+	for _, name := range ErrorLogVariables {
+		ngx.supported[name] = len(ngx.ops)
+		ngx.ops = append(ngx.ops, baseOp{
+			Type:  ngxVariable,
+			Extra: []byte(name),
+		})
+	}
+	return ngx
+}
+
+func UnmarshalErrorLog(data []byte, v interface{}) error {
+	fields, err := parseErrorLog(data, nil)
+	if err != nil {
+		return err
+	}
+	return decodeErrorLog(fields, v)
+}
+
+func UnmarshalErrorLogFromString(str string, v interface{}) error {
+	return UnmarshalErrorLog(NewStringReader(str).Bytes(), v)
+}
+
+type errorLogField struct {
+	name  string
+	value []byte
+}
+
+func decodeErrorLog(fields []errorLogField, v interface{}) error {
+	codec, ptr, err := errorLogFormat.decoderOf(v)
+	if err != nil {
+		return err
+	}
+	d, ok := codec.(fieldsDecoder)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal error log into %T", v)
+	}
+	return d.decodeFields(ptr, fields)
+}
+
+// A fieldsDecoder decodes a value from the named fields of an error log
+// entry, instead of from a line of a log format.
+type fieldsDecoder interface {
+	decodeFields(ptr unsafe.Pointer, fields []errorLogField) error
+}
+
+func (d *structCodec) decodeFields(ptr unsafe.Pointer, fields []errorLogField) error {
+	for _, def := range d.defaults {
+		if err := def.Codec.Decode(unsafe.Pointer(uintptr(ptr)+def.Offset), def.Value); err != nil {
+			return err
+		}
+	}
+	for i := range d.ops {
+		op := &d.ops[i]
+		if op.Type != ngxBind {
+			continue
+		}
+		bindPtr := unsafe.Pointer(uintptr(ptr) + op.Offset)
+		found := false
+		for _, f := range fields {
+			if f.name == string(op.Extra) {
+				if err := op.Codec.Decode(bindPtr, NewBytesReader(f.value)); err != nil {
+					return fmt.Errorf("field %q %w", op.Extra, err)
+				}
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if err := decodeMissing(op.Codec, bindPtr); err != nil {
+			return fmt.Errorf("field %q %w", op.Extra, err)
+		}
+	}
+	return nil
+}
+
+func (d *mapCodec) decodeFields(ptr unsafe.Pointer, fields []errorLogField) error {
+	for _, f := range fields {
+		key := d.keyType.UnsafeNew()
+		if err := d.keyCodec.Decode(key, NewStringReader(f.name)); err != nil {
+			return err
+		}
+		elem := d.elemType.UnsafeNew()
+		if err := d.elemCodec.Decode(elem, NewBytesReader(f.value)); err != nil {
+			return err
+		}
+		d.mapType.UnsafeSetIndex(ptr, key, elem)
+	}
+	return nil
+}
+
+// decodeFields decodes types that only have their own encoding with the codec
+// of their kind. Their own decoding reads lines of a log format, which error
+// log entries are not.
+func (d *hookCodec) decodeFields(ptr unsafe.Pointer, fields []errorLogField) error {
+	if fallback, ok := d.fallback.(fieldsDecoder); ok && !d.unmarshal && !d.textUnmarshal {
+		return fallback.decodeFields(ptr, fields)
+	}
+	return fmt.Errorf("%s cannot be unmarshaled from an error log entry", d.ptrType.Type1().Elem())
+}
+
+// isErrorLogStart reports whether line begins with a "yyyy/mm/dd hh:mm:ss"
+// timestamp, i.e. it starts a new entry instead of continuing the last one.
+func isErrorLogStart(line []byte) bool {
+	const layout = "0000/00/00 00:00:00"
+	if len(line) < len(layout) {
+		return false
+	}
+	for i := 0; i < len(layout); i++ {
+		if layout[i] == '0' {
+			if line[i] < '0' || line[i] > '9' {
+				return false
+			}
+		} else if line[i] != layout[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func parseDigits(data []byte, p int) int {
+	for p < len(data) && data[p] >= '0' && data[p] <= '9' {
+		p++
+	}
+	return p
+}
+
+// parseErrorLog splits an entry into its fields, appending them to fields.
+// Field values refer to data.
+func parseErrorLog(data []byte, fields []errorLogField) ([]errorLogField, error) {
+	if !isErrorLogStart(data) {
+		return nil, ErrInvalidErrorLog
+	}
+	fields = append(fields, errorLogField{"time", data[:19]})
+
+	p := 19
+	if !bytes.HasPrefix(data[p:], []byte(" [")) {
+		return nil, ErrInvalidErrorLog
+	}
+	p += 2
+	end := bytes.IndexByte(data[p:], ']')
+	if end < 0 {
+		return nil, ErrInvalidErrorLog
+	}
+	level := data[p : p+end]
+	known := false
+	for _, l := range ErrorLogLevels {
+		if string(level) == l {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("%w %q", ErrUnknownErrorLevel, level)
+	}
+	fields = append(fields, errorLogField{"level", level})
+	p += end + 1
+
+	if p >= len(data) || data[p] != ' ' {
+		return nil, ErrInvalidErrorLog
+	}
+	p++
+	q := parseDigits(data, p)
+	if q == p || q >= len(data) || data[q] != '#' {
+		return nil, ErrInvalidErrorLog
+	}
+	fields = append(fields, errorLogField{"pid", data[p:q]})
+	p = q + 1
+	q = parseDigits(data, p)
+	if q == p || !bytes.HasPrefix(data[q:], []byte(": ")) {
+		return nil, ErrInvalidErrorLog
+	}
+	fields = append(fields, errorLogField{"tid", data[p:q]})
+	p = q + 2
+
+	if p < len(data) && data[p] == '*' {
+		q = parseDigits(data, p+1)
+		if q > p+1 && q < len(data) && data[q] == ' ' {
+			fields = append(fields, errorLogField{"connection", data[p+1 : q]})
+			p = q + 1
+		}
+	}
+
+	msg := data[p:]
+	ctx := bytes.Index(msg, []byte(", client: "))
+	if ctx < 0 {
+		return append(fields, errorLogField{"message", msg}), nil
+	}
+	fields = append(fields, errorLogField{"message", msg[:ctx]})
+	return parseErrorLogContext(msg[ctx:], fields)
+}
+
+// parseErrorLogContext parses the ", key: value" pairs nginx appends to a
+// message, where values may be quoted.
+func parseErrorLogContext(ctx []byte, fields []errorLogField) ([]errorLogField, error) {
+	p := 0
+	for p < len(ctx) {
+		n := errorLogKeyAt(ctx, p)
+		if n <= 0 {
+			return nil, fmt.Errorf("%w: unexpected %q in context", ErrInvalidErrorLog, ctx[p:])
+		}
+		key := string(ctx[p+2 : p+n-1])
+		p += n
+		if p < len(ctx) && ctx[p] == ' ' {
+			p++
+		}
+
+		var value []byte
+		if p < len(ctx) && ctx[p] == '"' {
+			q := p + 1
+			for {
+				i := bytes.IndexByte(ctx[q:], '"')
+				if i < 0 {
+					return nil, fmt.Errorf("%w: unterminated value of %q", ErrInvalidErrorLog, key)
+				}
+				q += i
+				if q+1 == len(ctx) || errorLogKeyAt(ctx, q+1) > 0 {
+					break
+				}
+				q++
+			}
+			value = ctx[p+1 : q]
+			p = q + 1
+		} else {
+			q := p
+			for {
+				i := bytes.Index(ctx[q:], []byte(", "))
+				if i < 0 {
+					q = len(ctx)
+					break
+				}
+				q += i
+				if errorLogKeyAt(ctx, q) > 0 {
+					break
+				}
+				q += 2
+			}
+			value = ctx[p:q]
+			p = q
+		}
+		fields = append(fields, errorLogField{key, value})
+	}
+	return fields, nil
+}
+
+// errorLogKeyAt returns the length of the ", key:" prefix at ctx[p:], or 0 if
+// there is none.
+func errorLogKeyAt(ctx []byte, p int) int {
+	if !bytes.HasPrefix(ctx[p:], []byte(", ")) {
+		return 0
+	}
+	q := p + 2
+	if q >= len(ctx) || ctx[q] < 'a' || ctx[q] > 'z' {
+		return 0
+	}
+	for ; q < len(ctx); q++ {
+		switch ch := ctx[q]; {
+		case ch == ':':
+			return q + 1 - p
+		case (ch >= 'a' && ch <= 'z') || ch == '_' || ch == ' ' || ch == '/':
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
+// An ErrorLogDecoder reads and decodes error log entries from an input stream.
+// Lines that do not start with a timestamp continue the previous entry.
+type ErrorLogDecoder struct {
+	lr lineReader
+
+	entry  []byte
+	fields []errorLogField
+	lineno int
+	offset int64
+
+	next       []byte
+	nextLineno int
+	nextOffset int64
+	hasNext    bool
+}
+
+func NewErrorLogDecoder(r io.Reader) *ErrorLogDecoder {
+	return &ErrorLogDecoder{
+		lr: lineReader{
+			r:   r,
+			max: defaultMaxLineSize,
+		},
+	}
+}
+
+// SetMaxLineSize sets the maximum length of a line, excluding its line
+// terminator. Entries with longer lines make Decode return ErrLineTooLong.
+func (dec *ErrorLogDecoder) SetMaxLineSize(n int) {
+	if n > 0 {
+		dec.lr.max = n
+	}
+}
+
+// More reports whether there is another entry to decode.
+func (dec *ErrorLogDecoder) More() bool {
+	for !dec.hasNext {
+		line, err := dec.lr.next()
+		if err == ErrLineTooLong {
+			dec.next = dec.next[:0]
+		} else if err != nil {
+			return false
+		} else if len(line) == 0 {
+			continue
+		} else {
+			dec.next = append(dec.next[:0], line...)
+		}
+		dec.nextLineno, dec.nextOffset, dec.hasNext = dec.lr.lineno, dec.lr.offset, true
+	}
+	return true
+}
+
+// Decode decodes the next entry, including its continuation lines, into v.
+// It returns io.EOF when the input is exhausted.
+func (dec *ErrorLogDecoder) Decode(v interface{}) error {
+	if !dec.More() {
+		return dec.lr.err
+	}
+	dec.hasNext = false
+	dec.lineno, dec.offset = dec.nextLineno, dec.nextOffset
+	if len(dec.next) == 0 {
+		return fmt.Errorf("line %d: %w", dec.lineno, ErrLineTooLong)
+	}
+	dec.entry = append(dec.entry[:0], dec.next...)
+
+	for {
+		line, err := dec.lr.next()
+		if err != nil && err != ErrLineTooLong {
+			break
+		}
+		// an over-long line cannot be told apart from a continuation, so it is
+		// reported on its own.
+		if err == ErrLineTooLong || isErrorLogStart(line) {
+			dec.next = append(dec.next[:0], line...)
+			dec.nextLineno, dec.nextOffset, dec.hasNext = dec.lr.lineno, dec.lr.offset, true
+			break
+		}
+		dec.entry = append(dec.entry, '\n')
+		dec.entry = append(dec.entry, line...)
+	}
+
+	entry := detach(bytes.TrimRight(dec.entry, "\n"))
+	fields, err := parseErrorLog(NewStringReader(entry).Bytes(), dec.fields[:0])
+	if err != nil {
+		return fmt.Errorf("line %d: %w", dec.lineno, err)
+	}
+	dec.fields = fields
+
+	if err := decodeErrorLog(fields, v); err != nil {
+		return fmt.Errorf("line %d: %w", dec.lineno, err)
+	}
+	return nil
+}
+
+// Line returns the number of the first line of the last decoded entry.
+func (dec *ErrorLogDecoder) Line() int {
+	return dec.lineno
+}
+
+// Offset returns the byte offset of the last decoded entry in the input.
+func (dec *ErrorLogDecoder) Offset() int64 {
+	return dec.offset
+}
@@ -0,0 +1,166 @@
+package ngx
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var positiveErrorLog = []struct {
+	Data     string
+	Expected ErrorLog
+	Map      map[string]string
+}{
+	{
+		`2024/01/02 15:04:05 [error] 123#456: *789 open() "/usr/share/nginx/html/favicon.ico" failed (2: No such file or directory), client: 1.2.3.4, server: example.com, request: "GET /favicon.ico HTTP/1.1", host: "example.com", referrer: "http://example.com/"`,
+		ErrorLog{Time: "2024/01/02 15:04:05", Level: "error", PID: 123, TID: 456, Connection: 789, Message: `open() "/usr/share/nginx/html/favicon.ico" failed (2: No such file or directory)`, Client: "1.2.3.4", Server: "example.com", Request: "GET /favicon.ico HTTP/1.1", Host: "example.com", Referrer: "http://example.com/"},
+		map[string]string{"time": "2024/01/02 15:04:05", "level": "error", "pid": "123", "tid": "456", "connection": "789", "message": `open() "/usr/share/nginx/html/favicon.ico" failed (2: No such file or directory)`, "client": "1.2.3.4", "server": "example.com", "request": "GET /favicon.ico HTTP/1.1", "host": "example.com", "referrer": "http://example.com/"},
+	},
+	{
+		`2024/01/02 15:04:05 [notice] 1#1: signal process started`,
+		ErrorLog{Time: "2024/01/02 15:04:05", Level: "notice", PID: 1, TID: 1, Message: "signal process started"},
+		map[string]string{"time": "2024/01/02 15:04:05", "level": "notice", "pid": "1", "tid": "1", "message": "signal process started"},
+	},
+	{
+		`2024/01/02 15:04:05 [warn] 7#7: *1 upstream server temporarily disabled while reading response header from upstream, client: ::1, server: _, request: "GET /a, b: c HTTP/1.1", upstream: "http://127.0.0.1:8080/a, b: c", host: "localhost"`,
+		ErrorLog{Time: "2024/01/02 15:04:05", Level: "warn", PID: 7, TID: 7, Connection: 1, Message: "upstream server temporarily disabled while reading response header from upstream", Client: "::1", Server: "_", Request: "GET /a, b: c HTTP/1.1", Upstream: "http://127.0.0.1:8080/a, b: c", Host: "localhost"},
+		map[string]string{"time": "2024/01/02 15:04:05", "level": "warn", "pid": "7", "tid": "7", "connection": "1", "message": "upstream server temporarily disabled while reading response header from upstream", "client": "::1", "server": "_", "request": "GET /a, b: c HTTP/1.1", "upstream": "http://127.0.0.1:8080/a, b: c", "host": "localhost"},
+	},
+	{
+		`2024/01/02 15:04:05 [info] 7#7: *3 client 10.0.0.1:5000 connected to 0.0.0.0:53, client: 10.0.0.1, server: 0.0.0.0:53, upstream: "8.8.8.8:53", bytes from/to client:40/56, bytes from/to upstream:56/40`,
+		ErrorLog{Time: "2024/01/02 15:04:05", Level: "info", PID: 7, TID: 7, Connection: 3, Message: "client 10.0.0.1:5000 connected to 0.0.0.0:53", Client: "10.0.0.1", Server: "0.0.0.0:53", Upstream: "8.8.8.8:53"},
+		map[string]string{"time": "2024/01/02 15:04:05", "level": "info", "pid": "7", "tid": "7", "connection": "3", "message": "client 10.0.0.1:5000 connected to 0.0.0.0:53", "client": "10.0.0.1", "server": "0.0.0.0:53", "upstream": "8.8.8.8:53", "bytes from/to client": "40/56", "bytes from/to upstream": "56/40"},
+	},
+}
+
+var negativeErrorLog = []string{
+	``,
+	`2024/01/02 15:04:05`,
+	`2024/01/02 15:04:05 [fatal] 1#1: message`,
+	`2024/01/02 15:04:05 [error] 1: message`,
+	`2024/01/02 15:04:05 [error] 1#1 message`,
+	`2024-01-02 15:04:05 [error] 1#1: message`,
+	`2024/01/02 15:04:05 [error] 1#1: message, client: 1.1.1.1, request: "GET`,
+}
+
+func TestErrorLog(t *testing.T) {
+	for _, tc := range positiveErrorLog {
+		var got ErrorLog
+		if err := UnmarshalErrorLogFromString(tc.Data, &got); err != nil {
+			t.Fatalf("failed to UnmarshalErrorLog() data %q: %v", tc.Data, err)
+		}
+		if !reflect.DeepEqual(got, tc.Expected) {
+			t.Fatalf("corrupted data in UnmarshalErrorLog(): expecting %+v, got %+v", tc.Expected, got)
+		}
+
+		m := make(map[string]string)
+		if err := UnmarshalErrorLog([]byte(tc.Data), &m); err != nil {
+			t.Fatalf("failed to UnmarshalErrorLog() data %q: %v", tc.Data, err)
+		}
+		if !reflect.DeepEqual(m, tc.Map) {
+			t.Fatalf("corrupted data in UnmarshalErrorLog(): expecting %q, got %q", tc.Map, m)
+		}
+	}
+
+	for _, data := range negativeErrorLog {
+		if err := UnmarshalErrorLogFromString(data, new(ErrorLog)); err == nil {
+			t.Fatalf("expecting error on %q", data)
+		}
+	}
+}
+
+// errorLogLine has its own encoding but not its own decoding.
+type errorLogLine struct {
+	Level   string `ngx:"level"`
+	Message string `ngx:"message"`
+}
+
+func (e errorLogLine) MarshalNGX() ([]byte, error) {
+	return []byte(e.Level + ": " + e.Message), nil
+}
+
+func TestErrorLogHook(t *testing.T) {
+	var got errorLogLine
+	if err := UnmarshalErrorLogFromString(positiveErrorLog[1].Data, &got); err != nil {
+		t.Fatalf("failed to UnmarshalErrorLog() data %q: %v", positiveErrorLog[1].Data, err)
+	}
+	if expected := (errorLogLine{"notice", "signal process started"}); got != expected {
+		t.Fatalf("corrupted data in UnmarshalErrorLog(): expecting %+v, got %+v", expected, got)
+	}
+	if err := UnmarshalErrorLogFromString(positiveErrorLog[1].Data, new(rootLine)); err == nil {
+		t.Fatalf("expecting error decoding into a type with its own decoding")
+	}
+}
+
+func TestErrorLogTags(t *testing.T) {
+	type entry struct {
+		Level   string `ngx:"level,required"`
+		Client  string `ngx:"client,required"`
+		Server  string `ngx:"server,default=_"`
+		Version string `ngx:"version,default=1.25"`
+	}
+
+	var got entry
+	if err := UnmarshalErrorLogFromString(positiveErrorLog[0].Data, &got); err != nil {
+		t.Fatalf("failed to UnmarshalErrorLog() data %q: %v", positiveErrorLog[0].Data, err)
+	}
+	if expected := (entry{"error", "1.2.3.4", "example.com", "1.25"}); got != expected {
+		t.Fatalf("corrupted data in UnmarshalErrorLog(): expecting %+v, got %+v", expected, got)
+	}
+
+	data := positiveErrorLog[1].Data
+	if err := UnmarshalErrorLogFromString(data, &got); !errors.Is(err, ErrRequired) {
+		t.Fatalf("expecting ErrRequired on %q, got %v", data, err)
+	}
+	var opt struct {
+		Server string `ngx:"server,default=_"`
+	}
+	if err := UnmarshalErrorLogFromString(data, &opt); err != nil || opt.Server != "_" {
+		t.Fatalf("expecting the default server on %q, got %q, %v", data, opt.Server, err)
+	}
+}
+
+func TestErrorLogDecoder(t *testing.T) {
+	input := positiveErrorLog[0].Data + "\n" +
+		"2024/01/02 15:04:06 [error] 8#8: *2 FastCGI sent in stderr: \"PHP message: first\r\n" +
+		"PHP message: second\n" +
+		"\n" +
+		"PHP message: third\" while reading upstream, client: 1.1.1.1, server: x, request: \"GET / HTTP/1.1\"\n" +
+		"2024/01/02 15:04:07 [crit] 9#9: " + strings.Repeat("x", 256) + "\n" +
+		positiveErrorLog[1].Data
+
+	expected := []struct {
+		Line  int
+		Err   bool
+		Value ErrorLog
+	}{
+		{1, false, positiveErrorLog[0].Expected},
+		{2, false, ErrorLog{Time: "2024/01/02 15:04:06", Level: "error", PID: 8, TID: 8, Connection: 2, Message: "FastCGI sent in stderr: \"PHP message: first\nPHP message: second\n\nPHP message: third\" while reading upstream", Client: "1.1.1.1", Server: "x", Request: "GET / HTTP/1.1"}},
+		{6, true, ErrorLog{}},
+		{7, false, positiveErrorLog[1].Expected},
+	}
+
+	dec := NewErrorLogDecoder(strings.NewReader(input))
+	dec.SetMaxLineSize(255)
+	for _, tc := range expected {
+		if !dec.More() {
+			t.Fatalf("unexpected end of stream before line %d", tc.Line)
+		}
+		var got ErrorLog
+		err := dec.Decode(&got)
+		if (err != nil) != tc.Err {
+			t.Fatalf("line %d: unexpected error %v", tc.Line, err)
+		}
+		if dec.Line() != tc.Line {
+			t.Fatalf("expecting line %d, got %d", tc.Line, dec.Line())
+		}
+		if !reflect.DeepEqual(got, tc.Value) {
+			t.Fatalf("line %d: expecting %+v, got %+v", tc.Line, tc.Value, got)
+		}
+	}
+	if err := dec.Decode(new(ErrorLog)); err != io.EOF {
+		t.Fatalf("expecting io.EOF, got %v", err)
+	}
+}
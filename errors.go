@@ -0,0 +1,155 @@
+package ngx
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const maxSnippetSize = 32
+
+// A SyntaxError describes where a log line does not match its log format, or
+// where a log format passed to Compile is malformed.
+type SyntaxError struct {
+	Msg      string
+	Op       int    // index of the operator being matched
+	Variable string // name of the variable being matched, if any
+	Offset   int    // byte offset in the log line
+	Column   int    // 1-based column in the log format, for Compile errors
+	Snippet  string // text at Offset, or at Column
+	Err      error
+}
+
+func (e *SyntaxError) Error() string {
+	msg := e.Msg
+	if msg == "" && e.Err != nil {
+		msg = e.Err.Error()
+	}
+	if e.Column > 0 {
+		return fmt.Sprintf("column %d: %s", e.Column, msg)
+	}
+	return fmt.Sprintf("offset %d: %s", e.Offset, msg)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// A LiteralMismatchError describes a literal of the log format that is not
+// found where expected in a log line.
+type LiteralMismatchError struct {
+	Op       int // index of the literal operator
+	Offset   int // byte offset in the log line
+	Expected string
+	Got      string
+}
+
+func (e *LiteralMismatchError) Error() string {
+	return fmt.Sprintf("offset %d: got unexpected string %q, expecting %q", e.Offset, e.Got, e.Expected)
+}
+
+// A FieldError describes a value that could not be decoded into the field or
+// map element bound to its variable. It wraps the error of the codec.
+type FieldError struct {
+	Op       int    // index of the variable operator
+	Variable string // name of the variable
+	Field    string // name of the struct field, empty for maps
+	Offset   int    // byte offset of the raw value in the log line
+	Raw      string // raw value
+	Snippet  string // raw value, truncated
+	Err      error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("offset %d: field %q %v", e.Offset, e.Variable, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldErrors lists every value that failed to decode in lenient mode, see
+// Options.
+type FieldErrors []*FieldError
+
+func (e FieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the errors matches target, for errors.Is.
+func (e FieldErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target, for errors.As.
+func (e FieldErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// A BindingError describes a struct field or a variable of the log format
+// that Check finds does not bind as expected.
+type BindingError struct {
+	Op       int    // index of the variable operator, if any
+	Field    string // name of the struct field, empty for unbound variables
+	Variable string // name of the variable, empty for unbound fields
+	Msg      string
+}
+
+func (e *BindingError) Error() string {
+	switch {
+	case e.Variable == "":
+		return fmt.Sprintf("field %s: %s", e.Field, e.Msg)
+	case e.Field == "":
+		return fmt.Sprintf("variable %q: %s", e.Variable, e.Msg)
+	}
+	return fmt.Sprintf("field %s, variable %q: %s", e.Field, e.Variable, e.Msg)
+}
+
+// BindingErrors lists every problem Check finds.
+type BindingErrors []*BindingError
+
+func (e BindingErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// snippet returns at most maxSnippetSize bytes of data from p.
+func snippet(data []byte, p int) string {
+	if p >= len(data) {
+		return ""
+	}
+	data = data[p:]
+	if len(data) > maxSnippetSize {
+		data = data[:maxSnippetSize]
+	}
+	return string(data)
+}
+
+func fieldError(data []byte, s span, i int, op baseOp, field string, err error) *FieldError {
+	return &FieldError{
+		Op:       i,
+		Variable: string(op.Extra),
+		Field:    field,
+		Offset:   s.start,
+		Raw:      string(data[s.start:s.end]),
+		Snippet:  snippet(data[:s.end], s.start),
+		Err:      err,
+	}
+}
@@ -0,0 +1,115 @@
+package ngx
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDecodeErrors(t *testing.T) {
+	ngx, err := Compile(`[$status] $remote_addr "$request"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var literal *LiteralMismatchError
+	err = ngx.UnmarshalFromString(`(200] 127.0.0.1 "GET / HTTP/1.1"`, &Access{})
+	if !errors.As(err, &literal) {
+		t.Fatalf("expecting *LiteralMismatchError, got %v", err)
+	}
+	if literal.Op != 0 || literal.Offset != 0 || literal.Expected != "[" || literal.Got != "(" {
+		t.Fatalf("unexpected error %+v", literal)
+	}
+
+	var syntax *SyntaxError
+	err = ngx.UnmarshalFromString(`[200] 127.0.0.1`, &Access{})
+	if !errors.As(err, &syntax) {
+		t.Fatalf("expecting *SyntaxError, got %v", err)
+	}
+	if syntax.Op != 3 || syntax.Variable != "remote_addr" || syntax.Offset != 6 || syntax.Snippet != "127.0.0.1" {
+		t.Fatalf("unexpected error %+v", syntax)
+	}
+
+	var field *FieldError
+	err = ngx.UnmarshalFromString(`[2x0] 127.0.0.1 "GET / HTTP/1.1"`, &Access{})
+	if !errors.As(err, &field) {
+		t.Fatalf("expecting *FieldError, got %v", err)
+	}
+	if field.Op != 1 || field.Variable != "status" || field.Field != "Status" || field.Offset != 1 || field.Snippet != "2x0" {
+		t.Fatalf("unexpected error %+v", field)
+	}
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Fatalf("expecting the codec error to be wrapped, got %v", err)
+	}
+
+	err = ngx.UnmarshalFromString(`[2x0] 127.0.0.1 "GET / HTTP/1.1"`, &map[string]int{})
+	if !errors.As(err, &field) || field.Field != "" || field.Snippet != "2x0" {
+		t.Fatalf("expecting *FieldError, got %v", err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		Fmt    string
+		Column int
+		Err    error
+	}{
+		{`escape=unknown;$status`, 8, ErrUnknownLogFormatEscaping},
+		{`escape=json $status`, 13, ErrInvalidLogFormat},
+		{`$status ${request`, 9, ErrInvalidLogFormat},
+		{`$status $request.`, 9, ErrInvalidLogFormat},
+		{`[$host$request_uri]`, 2, ErrInvalidLogFormat},
+	} {
+		_, err := Compile(tc.Fmt)
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Fatalf("expecting *SyntaxError on %q, got %v", tc.Fmt, err)
+		}
+		if syntax.Column != tc.Column || !errors.Is(err, tc.Err) {
+			t.Fatalf("unexpected error on %q: column %d, %v", tc.Fmt, syntax.Column, err)
+		}
+	}
+}
+
+func TestLenient(t *testing.T) {
+	ngx, err := Compile(`[$status] $remote_addr $body_bytes_sent $bytes_sent`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := `[2x0] 127.0.0.1 12 -1`
+	if err := ngx.UnmarshalFromString(data, &Access{}); err == nil {
+		t.Fatalf("expecting error on %q", data)
+	}
+
+	ngx = ngx.WithOptions(Options{Lenient: true})
+	got := Access{Status: 200, BytesSent: 100}
+	err = ngx.UnmarshalFromString(data, &got)
+	var errs FieldErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Variable != "status" || errs[0].Snippet != "2x0" {
+		t.Fatalf("expecting FieldErrors on status, got %v", err)
+	}
+	var field *FieldError
+	if !errors.As(err, &field) || field.Variable != "status" || !errors.Is(err, strconv.ErrSyntax) {
+		t.Fatalf("expecting FieldErrors to wrap the FieldError on status, got %v", err)
+	}
+	expected := Access{RemoteAddr: "127.0.0.1", BodyBytesSent: 12, BytesSent: -1}
+	if got != expected {
+		t.Fatalf("corrupted data in lenient UnmarshalFromString(): expecting %+v, got %+v", expected, got)
+	}
+
+	m := map[string]uint{}
+	err = ngx.UnmarshalFromString(data, &m)
+	if !errors.As(err, &errs) || len(errs) != 3 || errs[2].Variable != "bytes_sent" {
+		t.Fatalf("expecting FieldErrors on status, remote_addr and bytes_sent, got %v", err)
+	}
+	if len(m) != 1 || m["body_bytes_sent"] != 12 {
+		t.Fatalf("corrupted data in lenient UnmarshalFromString(): got %v", m)
+	}
+
+	raw := strings.Repeat("x", 2*maxSnippetSize)
+	err = ngx.UnmarshalFromString(`[`+raw+`] 127.0.0.1 12 -1`, &Access{})
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Raw != raw || errs[0].Snippet != raw[:maxSnippetSize] {
+		t.Fatalf("expecting the raw value of status in FieldErrors, got %+v", errs)
+	}
+}
@@ -62,14 +62,37 @@ func (e Esc) Escape(buf []byte) []byte {
 	}
 }
 
+// Unescape returns buf unescaped. It returns buf itself if nothing needs
+// unescaping.
 func (e Esc) Unescape(buf []byte) ([]byte, error) {
+	if !e.escaped(buf) {
+		return buf, nil
+	}
+	w := AcquireWriter()
+	err := e.unescapeTo(w, buf)
+	raw := w.CopyBytes()
+	ReleaseWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// escaped reports whether buf contains escape sequences.
+func (e Esc) escaped(buf []byte) bool {
+	return e != EscNone && bytes.IndexByte(buf, '\\') >= 0
+}
+
+// unescapeTo writes buf unescaped to w.
+func (e Esc) unescapeTo(w *writer, buf []byte) error {
 	switch e {
 	case EscDefault:
-		return unescape(buf)
+		return unescape(w, buf)
 	case EscJson:
-		return junescape(buf)
+		return junescape(w, buf)
 	default:
-		return buf, nil
+		_, err := w.Write(buf)
+		return err
 	}
 }
 
@@ -131,13 +154,9 @@ func escape(buf []byte) []byte {
 	return esc
 }
 
-func unescape(buf []byte) ([]byte, error) {
+// unescape writes the unescaped buf to w.
+func unescape(w *writer, buf []byte) error {
 	length := len(buf)
-	if length <= 0 {
-		return buf, nil
-	}
-
-	w := AcquireWriter()
 
 	for i := 0; i < length; i++ {
 		backslash := bytes.IndexByte(buf[i:], '\\')
@@ -151,7 +170,7 @@ func unescape(buf []byte) ([]byte, error) {
 
 		backslash++
 		if backslash >= length {
-			return nil, errors.New("found EOF while unescaping '\\' format")
+			return errors.New("found EOF while unescaping '\\' format")
 		}
 		switch ch := buf[backslash]; ch {
 		case '\\', '"':
@@ -162,20 +181,18 @@ func unescape(buf []byte) ([]byte, error) {
 					w.WriteByte(byte(heximal[buf[backslash+1]]<<4 | heximal[buf[backslash+2]]))
 					backslash += 2
 				} else {
-					return nil, fmt.Errorf("found invalid hex escape format \\x%c%c", buf[backslash+1], buf[backslash+2])
+					return fmt.Errorf("found invalid hex escape format \\x%c%c", buf[backslash+1], buf[backslash+2])
 				}
 			} else {
-				return nil, errors.New("found EOF while unescaping '\\x??' format")
+				return errors.New("found EOF while unescaping '\\x??' format")
 			}
 		default:
-			return nil, fmt.Errorf("found unknown escape format '\\%c'", ch)
+			return fmt.Errorf("found unknown escape format '\\%c'", ch)
 		}
 		i = backslash
 	}
 
-	raw := w.CopyBytes()
-	ReleaseWriter(w)
-	return raw, nil
+	return nil
 }
 
 func jescape(buf []byte) []byte {
@@ -226,13 +243,9 @@ func jescape(buf []byte) []byte {
 	return esc
 }
 
-func junescape(buf []byte) ([]byte, error) {
+// junescape writes the JSON unescaped buf to w.
+func junescape(w *writer, buf []byte) error {
 	length := len(buf)
-	if length <= 0 {
-		return buf, nil
-	}
-
-	w := AcquireWriter()
 
 	for i := 0; i < length; i++ {
 		backslash := bytes.IndexByte(buf[i:], '\\')
@@ -246,7 +259,7 @@ func junescape(buf []byte) ([]byte, error) {
 
 		backslash++
 		if backslash >= length {
-			return nil, errors.New("found EOF while unescaping '\\' format")
+			return errors.New("found EOF while unescaping '\\' format")
 		}
 		switch ch := buf[backslash]; ch {
 		case '\\', '"', '/':
@@ -289,7 +302,7 @@ func junescape(buf []byte) ([]byte, error) {
 								}
 								backslash = next + 1
 							} else {
-								return nil, fmt.Errorf("found invalid unicode escape format \\u%c%c%c%c", buf[next+2], buf[next+3], buf[next+4], buf[next+5])
+								return fmt.Errorf("found invalid unicode escape format \\u%c%c%c%c", buf[next+2], buf[next+3], buf[next+4], buf[next+5])
 							}
 						} else {
 							appendRune(w, r)
@@ -299,20 +312,18 @@ func junescape(buf []byte) ([]byte, error) {
 					}
 					backslash += 4
 				} else {
-					return nil, fmt.Errorf("found invalid unicode escape format \\u%c%c%c%c", buf[backslash+1], buf[backslash+2], buf[backslash+3], buf[backslash+4])
+					return fmt.Errorf("found invalid unicode escape format \\u%c%c%c%c", buf[backslash+1], buf[backslash+2], buf[backslash+3], buf[backslash+4])
 				}
 			} else {
-				return nil, errors.New("found EOF while unescaping '\\u??' format")
+				return errors.New("found EOF while unescaping '\\u??' format")
 			}
 		default:
-			return nil, fmt.Errorf("found unknown escape format '\\%c'", ch)
+			return fmt.Errorf("found unknown escape format '\\%c'", ch)
 		}
 		i = backslash
 	}
 
-	raw := w.CopyBytes()
-	ReleaseWriter(w)
-	return raw, nil
+	return nil
 }
 
 const (
@@ -0,0 +1,163 @@
+package ngx
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrNoFormat = errors.New("No log format matches")
+
+// A FormatSet decodes lines written in any of several log formats. Formats
+// are tried in turn, the ones matching most lines first, and a line matches
+// a format if it splits into its variables and every value is valid for its
+// variable (see Variable.Validate). It is safe for concurrent use.
+type FormatSet struct {
+	mu      sync.RWMutex
+	formats []*setFormat // in the order they are tried
+}
+
+type setFormat struct {
+	hits uint64 // first for atomic alignment
+	name string
+	ngx  *NGX
+
+	prefix, suffix []byte // literals every line starts and ends with
+}
+
+// NewFormatSet returns an empty FormatSet.
+func NewFormatSet() *FormatSet {
+	return &FormatSet{}
+}
+
+// Add adds the format ngx under name, to be tried after the formats added
+// earlier until it matches more lines than them.
+func (s *FormatSet) Add(name string, ngx *NGX) {
+	f := &setFormat{name: name, ngx: ngx}
+	if ops := ngx.ops; len(ops) > 0 {
+		if ops[0].Type != ngxVariable {
+			f.prefix = ops[0].Extra
+		}
+		if last := ops[len(ops)-1]; last.Type != ngxVariable {
+			f.suffix = last.Extra
+		}
+	}
+	s.mu.Lock()
+	s.formats = append(s.formats, f)
+	s.mu.Unlock()
+}
+
+// Names returns the names of the formats in the order they are tried.
+func (s *FormatSet) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, len(s.formats))
+	for i, f := range s.formats {
+		names[i] = f.name
+	}
+	return names
+}
+
+// Match returns the name and the format of the first format matching line.
+func (s *FormatSet) Match(line []byte) (string, *NGX, bool) {
+	s.mu.RLock()
+	for i, f := range s.formats {
+		if !f.matches(line) {
+			continue
+		}
+		hits := atomic.AddUint64(&f.hits, 1)
+		promote := i > 0 && hits > atomic.LoadUint64(&s.formats[i-1].hits)
+		s.mu.RUnlock()
+		if promote {
+			s.promote(f)
+		}
+		return f.name, f.ngx, true
+	}
+	s.mu.RUnlock()
+	return "", nil, false
+}
+
+// promote moves f before the formats matching fewer lines.
+func (s *FormatSet) promote(f *setFormat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.formats {
+		if s.formats[i] != f {
+			continue
+		}
+		for ; i > 0 && atomic.LoadUint64(&f.hits) > atomic.LoadUint64(&s.formats[i-1].hits); i-- {
+			s.formats[i], s.formats[i-1] = s.formats[i-1], f
+		}
+		return
+	}
+}
+
+// Unmarshal decodes data with the first format matching it into v, and
+// returns the name of the format.
+func (s *FormatSet) Unmarshal(data []byte, v interface{}) (string, error) {
+	name, ngx, ok := s.Match(data)
+	if !ok {
+		return "", ErrNoFormat
+	}
+	return name, ngx.Unmarshal(data, v)
+}
+
+// UnmarshalFromString is Unmarshal for a string.
+func (s *FormatSet) UnmarshalFromString(str string, v interface{}) (string, error) {
+	name, ngx, ok := s.Match(NewStringReader(str).Bytes())
+	if !ok {
+		return "", ErrNoFormat
+	}
+	return name, ngx.UnmarshalFromString(str, v)
+}
+
+// Detect reads up to n lines from r and returns the name of the format
+// matching most of them. It does not change the order formats are tried in.
+func (s *FormatSet) Detect(r io.Reader, n int) (string, error) {
+	s.mu.RLock()
+	formats := append([]*setFormat(nil), s.formats...)
+	s.mu.RUnlock()
+
+	counts := make([]int, len(formats))
+	lr := lineReader{r: r, max: defaultMaxLineSize}
+	for read := 0; read < n; {
+		line, err := lr.next()
+		if err == ErrLineTooLong {
+			continue
+		} else if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		read++
+		for i, f := range formats {
+			if f.matches(line) {
+				counts[i]++
+			}
+		}
+	}
+
+	best := -1
+	for i, count := range counts {
+		if count > 0 && (best < 0 || count > counts[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", ErrNoFormat
+	}
+	return formats[best].name, nil
+}
+
+func (f *setFormat) matches(line []byte) bool {
+	if !bytes.HasPrefix(line, f.prefix) || !bytes.HasSuffix(line, f.suffix) {
+		return false
+	}
+	return f.ngx.validate(line, true) == nil
+}
@@ -0,0 +1,90 @@
+package ngx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	setCombined = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`
+	setMain     = setCombined + ` "$http_x_forwarded_for"`
+	setJSON     = `escape=json;{"status":"$status","host":"$host"}`
+
+	combinedLine = `127.0.0.1 - - [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 200 612 "-" "curl/7.68.0"`
+	mainLine     = combinedLine + ` "10.0.0.1"`
+	jsonLine     = `{"status":"404","host":"example.com"}`
+)
+
+func newTestFormatSet(t *testing.T) *FormatSet {
+	s := NewFormatSet()
+	for _, f := range []struct{ Name, Format string }{
+		{"combined", setCombined},
+		{"main", setMain},
+		{"json", setJSON},
+	} {
+		ngx, err := Compile(f.Format)
+		if err != nil {
+			t.Fatalf("unexpected error compiling %q: %s", f.Name, err)
+		}
+		s.Add(f.Name, ngx)
+	}
+	return s
+}
+
+func TestFormatSet(t *testing.T) {
+	s := newTestFormatSet(t)
+	for _, tc := range []struct {
+		Line     string
+		Expected string
+	}{
+		{combinedLine, "combined"},
+		{mainLine, "main"},
+		{strings.Replace(combinedLine, "13:55:36 +0000", "06:55:36 -0700", 1), "combined"},
+		{jsonLine, "json"},
+		{`{"status":"20","host":"example.com"}`, ""},
+		{"garbage", ""},
+	} {
+		name, _, ok := s.Match([]byte(tc.Line))
+		if ok != (tc.Expected != "") || name != tc.Expected {
+			t.Fatalf("unexpected match for %q: expecting %q, got %q", tc.Line, tc.Expected, name)
+		}
+	}
+
+	v := make(map[string]string)
+	name, err := s.UnmarshalFromString(jsonLine, &v)
+	if err != nil || name != "json" || v["host"] != "example.com" {
+		t.Fatalf("unexpected result decoding %q: %q, %v, %s", jsonLine, name, v, err)
+	}
+	if _, err := s.UnmarshalFromString("garbage", &v); err != ErrNoFormat {
+		t.Fatalf("unexpected error decoding garbage: %v", err)
+	}
+}
+
+func TestFormatSetPromote(t *testing.T) {
+	s := newTestFormatSet(t)
+	for i := 0; i < 3; i++ {
+		s.Match([]byte(jsonLine))
+	}
+	s.Match([]byte(mainLine))
+	s.Match([]byte(mainLine))
+	expected := []string{"json", "main", "combined"}
+	if names := s.Names(); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected order: expecting %q, got %q", expected, names)
+	}
+}
+
+func TestFormatSetDetect(t *testing.T) {
+	s := newTestFormatSet(t)
+	sample := strings.Join([]string{mainLine, "", jsonLine, mainLine, "garbage", combinedLine}, "\n")
+	name, err := s.Detect(strings.NewReader(sample), 10)
+	if err != nil || name != "main" {
+		t.Fatalf("unexpected detected format: %q, %v", name, err)
+	}
+	if _, err := s.Detect(strings.NewReader("garbage\n"), 10); err != ErrNoFormat {
+		t.Fatalf("unexpected error detecting garbage: %v", err)
+	}
+	if names := s.Names(); names[0] != "combined" {
+		t.Fatalf("Detect changed the order: %q", names)
+	}
+}
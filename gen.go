@@ -0,0 +1,79 @@
+package ngx
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/modern-go/reflect2"
+)
+
+// The functions below are used by the code cmd/ngxgen generates, for the
+// values it does not decode or encode inline.
+
+var valueCodecs sync.Map // valueKey -> Codec
+
+type valueKey struct {
+	esc  Esc
+	name string
+	typ  reflect.Type
+}
+
+func valueCodec(esc Esc, name string, ptrType reflect2.Type) (Codec, error) {
+	if ptrType.Kind() != reflect.Ptr {
+		return nil, ErrNonPointer
+	}
+	key := valueKey{esc, name, ptrType.Type1()}
+	if codec, ok := valueCodecs.Load(key); ok {
+		return codec.(Codec), nil
+	}
+	codec, err := codecOfVar(&NGX{esc: esc}, name, ptrType.(*reflect2.UnsafePtrType).Elem())
+	if err != nil {
+		return nil, err
+	}
+	valueCodecs.Store(key, codec)
+	return codec, nil
+}
+
+// IndexValue returns the length of the raw value of the variable name at the
+// start of data, which ends before the literal delim in a log format escaping
+// with esc, or -1.
+func IndexValue(esc Esc, name string, data, delim []byte) int {
+	return indexValue(esc, NewStringReader(name).Bytes(), data, delim)
+}
+
+// ParseFloat parses raw as a decimal number, as NGX decodes floats: unlike
+// strconv.ParseFloat, it rejects exponents, hex floats, NaN and Inf.
+func ParseFloat(raw []byte, bitSize int) (float64, error) {
+	return parseFloat(string(raw), bitSize)
+}
+
+// DecodeValue decodes raw, the unescaped value of the variable name, into the
+// value v points to, as an NGX escaping with esc does for a struct field.
+func DecodeValue(esc Esc, name string, raw []byte, v interface{}) error {
+	codec, err := valueCodec(esc, name, reflect2.TypeOf(v))
+	if err != nil {
+		return err
+	}
+	return codec.Decode(reflect2.PtrOf(v), NewBytesReader(raw))
+}
+
+// AppendValue appends the escaped value v points to, as an NGX escaping with
+// esc encodes a struct field bound to the variable name, to dst. On error,
+// dst is returned unmodified.
+func AppendValue(dst []byte, esc Esc, name string, v interface{}) ([]byte, error) {
+	codec, err := valueCodec(esc, name, reflect2.TypeOf(v))
+	if err != nil {
+		return dst, err
+	}
+	n := len(dst)
+	w := AcquireWriter()
+	buf := w.buf
+	w.buf = dst
+	err = codec.Encode(reflect2.PtrOf(v), w)
+	dst, w.buf = w.buf, buf
+	ReleaseWriter(w)
+	if err != nil {
+		return dst[:n], err
+	}
+	return dst, nil
+}
@@ -0,0 +1,71 @@
+package ngx_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tr3ee/ngx-go"
+)
+
+//go:generate go run ./cmd/ngxgen -type genAccess -const genFormat -output genaccess_ngx_test.go
+
+const genFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent $request_time $upstream_response_time "$http_user_agent" $cached`
+
+type genAccess struct {
+	RemoteAddr    string        `ngx:"remote_addr"`
+	RemoteUser    []byte        `ngx:"remote_user"`
+	Time          time.Time     `ngx:"time_local"`
+	Request       string        `ngx:"request"`
+	Status        int           `ngx:"status"`
+	BodyBytesSent uint64        `ngx:"body_bytes_sent"`
+	RequestTime   float64       `ngx:"request_time"`
+	UpstreamTime  time.Duration `ngx:"upstream_response_time"`
+	Cached        bool          `ngx:"cached"`
+	Ignored       string        `ngx:"_"`
+}
+
+// plainAccess is genAccess without the generated methods.
+type plainAccess genAccess
+
+func TestGenerated(t *testing.T) {
+	logfmt, err := ngx.Compile(genFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`127.0.0.1 - frank [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 200 612 0.004 0.003 "curl/7.68.0" true`,
+		`::1 - - [10/Oct/2020:13:55:36 +0000] "GET /\x22a\x22 HTTP/1.1" 404 0 - - "-" TRUE`,
+		`::1 - - [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 2x0 0 - - "-" false`,
+		`::1 - - 10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 200 0 - - "-" false`,
+		`::1 - - [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 200 0 - -`,
+	} {
+		var gen genAccess
+		var plain plainAccess
+		genErr := gen.UnmarshalNGX([]byte(line))
+		plainErr := logfmt.UnmarshalFromString(line, &plain)
+		if (genErr == nil) != (plainErr == nil) || (genErr != nil && genErr.Error() != plainErr.Error()) {
+			t.Fatalf("unexpected error decoding %q: expecting %v, got %v", line, plainErr, genErr)
+		}
+		if !reflect.DeepEqual(plainAccess(gen), plain) {
+			t.Fatalf("unexpected value decoding %q:\nexpecting %+v\ngot       %+v", line, plain, gen)
+		}
+		if genErr != nil {
+			continue
+		}
+
+		var viaNGX genAccess
+		if err := logfmt.UnmarshalFromString(line, &viaNGX); err != nil || !reflect.DeepEqual(viaNGX, gen) {
+			t.Fatalf("unexpected result decoding %q with the generated methods: %+v, %v", line, viaNGX, err)
+		}
+
+		encoded, err := gen.MarshalNGX()
+		if err != nil {
+			t.Fatalf("unexpected error encoding %+v: %s", gen, err)
+		}
+		expected, _ := logfmt.MarshalToString(&plain)
+		if string(encoded) != expected {
+			t.Fatalf("unexpected encoding of %+v:\nexpecting %s\ngot       %s", gen, expected, encoded)
+		}
+	}
+}
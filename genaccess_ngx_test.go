@@ -0,0 +1,233 @@
+// Code generated by ngxgen -type genAccess. DO NOT EDIT.
+
+package ngx_test
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/tr3ee/ngx-go"
+)
+
+const ngxGenAccessFormat = "$remote_addr - $remote_user [$time_local] \"$request\" $status $body_bytes_sent $request_time $upstream_response_time \"$http_user_agent\" $cached"
+
+var ngxGenAccessLiterals = [...][]byte{
+	[]byte(" - "),
+	[]byte(" ["),
+	[]byte("] \""),
+	[]byte("\" "),
+	[]byte(" "),
+	[]byte(" "),
+	[]byte(" "),
+	[]byte(" \""),
+	[]byte("\" "),
+}
+
+// NGXFormat returns the log format UnmarshalNGX and MarshalNGX are generated for.
+func (*genAccess) NGXFormat() string {
+	return ngxGenAccessFormat
+}
+
+// UnmarshalNGX decodes a line of the log format into v.
+func (v *genAccess) UnmarshalNGX(data []byte) error {
+	var (
+		p     int
+		spans [18]int
+		raw   []byte
+		err   error
+		n     int
+		i64   int64
+		u64   uint64
+		f64   float64
+	)
+	if n = ngx.IndexValue(ngx.EscDefault, "remote_addr", data[p:], ngxGenAccessLiterals[0]); n < 0 {
+		return &ngx.SyntaxError{Msg: "got unexpected EOF: expecting \" - \" after $remote_addr", Op: 0, Variable: "remote_addr", Offset: p}
+	}
+	spans[0], spans[1] = p, p+n
+	p += n + 3
+	if n = ngx.IndexValue(ngx.EscDefault, "remote_user", data[p:], ngxGenAccessLiterals[1]); n < 0 {
+		return &ngx.SyntaxError{Msg: "got unexpected EOF: expecting \" [\" after $remote_user", Op: 2, Variable: "remote_user", Offset: p}
+	}
+	spans[2], spans[3] = p, p+n
+	p += n + 2
+	if n = ngx.IndexValue(ngx.EscDefault, "time_local", data[p:], ngxGenAccessLiterals[2]); n < 0 {
+		return &ngx.SyntaxError{Msg: "got unexpected EOF: expecting \"] \\\"\" after $time_local", Op: 4, Variable: "time_local", Offset: p}
+	}
+	spans[4], spans[5] = p, p+n
+	p += n + 3
+	if n = ngx.IndexValue(ngx.EscDefault, "request", data[p:], ngxGenAccessLiterals[3]); n < 0 {
+		return &ngx.SyntaxError{Msg: "got unexpected EOF: expecting \"\\\" \" after $request", Op: 6, Variable: "request", Offset: p}
+	}
+	spans[6], spans[7] = p, p+n
+	p += n + 2
+	if n = ngx.IndexValue(ngx.EscDefault, "status", data[p:], ngxGenAccessLiterals[4]); n < 0 {
+		return &ngx.SyntaxError{Msg: "got unexpected EOF: expecting \" \" after $status", Op: 8, Variable: "status", Offset: p}
+	}
+	spans[8], spans[9] = p, p+n
+	p += n + 1
+	if n = ngx.IndexValue(ngx.EscDefault, "body_bytes_sent", data[p:], ngxGenAccessLiterals[5]); n < 0 {
+		return &ngx.SyntaxError{Msg: "got unexpected EOF: expecting \" \" after $body_bytes_sent", Op: 10, Variable: "body_bytes_sent", Offset: p}
+	}
+	spans[10], spans[11] = p, p+n
+	p += n + 1
+	if n = ngx.IndexValue(ngx.EscDefault, "request_time", data[p:], ngxGenAccessLiterals[6]); n < 0 {
+		return &ngx.SyntaxError{Msg: "got unexpected EOF: expecting \" \" after $request_time", Op: 12, Variable: "request_time", Offset: p}
+	}
+	spans[12], spans[13] = p, p+n
+	p += n + 1
+	if n = ngx.IndexValue(ngx.EscDefault, "upstream_response_time", data[p:], ngxGenAccessLiterals[7]); n < 0 {
+		return &ngx.SyntaxError{Msg: "got unexpected EOF: expecting \" \\\"\" after $upstream_response_time", Op: 14, Variable: "upstream_response_time", Offset: p}
+	}
+	spans[14], spans[15] = p, p+n
+	p += n + 2
+	if n = ngx.IndexValue(ngx.EscDefault, "http_user_agent", data[p:], ngxGenAccessLiterals[8]); n < 0 {
+		return &ngx.SyntaxError{Msg: "got unexpected EOF: expecting \"\\\" \" after $http_user_agent", Op: 16, Variable: "http_user_agent", Offset: p}
+	}
+	p += n + 2
+	spans[16], spans[17] = p, len(data)
+
+	// $remote_addr
+	if raw, err = ngx.EscDefault.Unescape(data[spans[0]:spans[1]]); err == nil {
+		v.RemoteAddr = string(raw)
+	}
+	if err != nil {
+		if raw = data[spans[0]:spans[1]]; len(raw) > 32 {
+			raw = raw[:32]
+		}
+		return &ngx.FieldError{Op: 0, Variable: "remote_addr", Field: "RemoteAddr", Offset: spans[0], Raw: string(data[spans[0]:spans[1]]), Snippet: string(raw), Err: err}
+	}
+
+	// $remote_user
+	if raw, err = ngx.EscDefault.Unescape(data[spans[2]:spans[3]]); err == nil {
+		v.RemoteUser = append([]byte(nil), raw...)
+	}
+	if err != nil {
+		if raw = data[spans[2]:spans[3]]; len(raw) > 32 {
+			raw = raw[:32]
+		}
+		return &ngx.FieldError{Op: 2, Variable: "remote_user", Field: "RemoteUser", Offset: spans[2], Raw: string(data[spans[2]:spans[3]]), Snippet: string(raw), Err: err}
+	}
+
+	// $time_local
+	if raw, err = ngx.EscDefault.Unescape(data[spans[4]:spans[5]]); err == nil {
+		err = ngx.DecodeValue(ngx.EscDefault, "time_local", raw, &v.Time)
+	}
+	if err != nil {
+		if raw = data[spans[4]:spans[5]]; len(raw) > 32 {
+			raw = raw[:32]
+		}
+		return &ngx.FieldError{Op: 4, Variable: "time_local", Field: "Time", Offset: spans[4], Raw: string(data[spans[4]:spans[5]]), Snippet: string(raw), Err: err}
+	}
+
+	// $request
+	if raw, err = ngx.EscDefault.Unescape(data[spans[6]:spans[7]]); err == nil {
+		v.Request = string(raw)
+	}
+	if err != nil {
+		if raw = data[spans[6]:spans[7]]; len(raw) > 32 {
+			raw = raw[:32]
+		}
+		return &ngx.FieldError{Op: 6, Variable: "request", Field: "Request", Offset: spans[6], Raw: string(data[spans[6]:spans[7]]), Snippet: string(raw), Err: err}
+	}
+
+	// $status
+	if raw, err = ngx.EscDefault.Unescape(data[spans[8]:spans[9]]); err == nil {
+		if len(raw) == 0 || string(raw) == "-" {
+			v.Status = 0
+		} else if i64, err = strconv.ParseInt(string(raw), 10, 0); err == nil {
+			v.Status = int(i64)
+		}
+	}
+	if err != nil {
+		if raw = data[spans[8]:spans[9]]; len(raw) > 32 {
+			raw = raw[:32]
+		}
+		return &ngx.FieldError{Op: 8, Variable: "status", Field: "Status", Offset: spans[8], Raw: string(data[spans[8]:spans[9]]), Snippet: string(raw), Err: err}
+	}
+
+	// $body_bytes_sent
+	if raw, err = ngx.EscDefault.Unescape(data[spans[10]:spans[11]]); err == nil {
+		if len(raw) == 0 || string(raw) == "-" {
+			v.BodyBytesSent = 0
+		} else if u64, err = strconv.ParseUint(string(raw), 10, 64); err == nil {
+			v.BodyBytesSent = u64
+		}
+	}
+	if err != nil {
+		if raw = data[spans[10]:spans[11]]; len(raw) > 32 {
+			raw = raw[:32]
+		}
+		return &ngx.FieldError{Op: 10, Variable: "body_bytes_sent", Field: "BodyBytesSent", Offset: spans[10], Raw: string(data[spans[10]:spans[11]]), Snippet: string(raw), Err: err}
+	}
+
+	// $request_time
+	if raw, err = ngx.EscDefault.Unescape(data[spans[12]:spans[13]]); err == nil {
+		if len(raw) == 0 || string(raw) == "-" {
+			v.RequestTime = 0
+		} else if f64, err = ngx.ParseFloat(raw, 64); err == nil {
+			v.RequestTime = f64
+		}
+	}
+	if err != nil {
+		if raw = data[spans[12]:spans[13]]; len(raw) > 32 {
+			raw = raw[:32]
+		}
+		return &ngx.FieldError{Op: 12, Variable: "request_time", Field: "RequestTime", Offset: spans[12], Raw: string(data[spans[12]:spans[13]]), Snippet: string(raw), Err: err}
+	}
+
+	// $upstream_response_time
+	if raw, err = ngx.EscDefault.Unescape(data[spans[14]:spans[15]]); err == nil {
+		err = ngx.DecodeValue(ngx.EscDefault, "upstream_response_time", raw, &v.UpstreamTime)
+	}
+	if err != nil {
+		if raw = data[spans[14]:spans[15]]; len(raw) > 32 {
+			raw = raw[:32]
+		}
+		return &ngx.FieldError{Op: 14, Variable: "upstream_response_time", Field: "UpstreamTime", Offset: spans[14], Raw: string(data[spans[14]:spans[15]]), Snippet: string(raw), Err: err}
+	}
+
+	// $cached
+	if raw, err = ngx.EscDefault.Unescape(data[spans[16]:spans[17]]); err == nil {
+		v.Cached = bytes.EqualFold(raw, []byte("true"))
+	}
+	if err != nil {
+		if raw = data[spans[16]:spans[17]]; len(raw) > 32 {
+			raw = raw[:32]
+		}
+		return &ngx.FieldError{Op: 18, Variable: "cached", Field: "Cached", Offset: spans[16], Raw: string(data[spans[16]:spans[17]]), Snippet: string(raw), Err: err}
+	}
+	return nil
+}
+
+// MarshalNGX encodes v as a line of the log format.
+func (v *genAccess) MarshalNGX() ([]byte, error) {
+	var err error
+	buf := make([]byte, 0, 256)
+	buf = append(buf, ngx.EscDefault.Escape([]byte(v.RemoteAddr))...)
+	buf = append(buf, ngxGenAccessLiterals[0]...)
+	buf = append(buf, ngx.EscDefault.Escape(v.RemoteUser)...)
+	buf = append(buf, ngxGenAccessLiterals[1]...)
+	if buf, err = ngx.AppendValue(buf, ngx.EscDefault, "time_local", &v.Time); err != nil {
+		return nil, fmt.Errorf("field %q %v", "time_local", err)
+	}
+	buf = append(buf, ngxGenAccessLiterals[2]...)
+	buf = append(buf, ngx.EscDefault.Escape([]byte(v.Request))...)
+	buf = append(buf, ngxGenAccessLiterals[3]...)
+	buf = strconv.AppendInt(buf, int64(v.Status), 10)
+	buf = append(buf, ngxGenAccessLiterals[4]...)
+	buf = strconv.AppendUint(buf, uint64(v.BodyBytesSent), 10)
+	buf = append(buf, ngxGenAccessLiterals[5]...)
+	if buf, err = ngx.AppendValue(buf, ngx.EscDefault, "request_time", &v.RequestTime); err != nil {
+		return nil, fmt.Errorf("field %q %v", "request_time", err)
+	}
+	buf = append(buf, ngxGenAccessLiterals[6]...)
+	if buf, err = ngx.AppendValue(buf, ngx.EscDefault, "upstream_response_time", &v.UpstreamTime); err != nil {
+		return nil, fmt.Errorf("field %q %v", "upstream_response_time", err)
+	}
+	buf = append(buf, ngxGenAccessLiterals[7]...)
+	buf = append(buf, "-"...)
+	buf = append(buf, ngxGenAccessLiterals[8]...)
+	buf = strconv.AppendBool(buf, v.Cached)
+	return buf, nil
+}
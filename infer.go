@@ -0,0 +1,363 @@
+package ngx
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrCannotInfer = errors.New("Cannot infer the log format")
+
+// sampleValue is a value of a sample line, without its quotes or brackets.
+type sampleValue struct {
+	kind  byte // '"' if quoted, '[' if bracketed, 0 otherwise
+	value string
+}
+
+// inferField holds the values found at the same place in every sample line.
+type inferField struct {
+	kind   byte
+	values []string
+}
+
+// inferGuess is a variable a field may be bound to. typed guesses check the
+// grammar of the variable, so a field that is the same in every line and
+// passes one is still a variable. The others are only tried for the kinds of
+// field listed in inferUntyped.
+type inferGuess struct {
+	name  string
+	typed bool
+	test  func(value string) bool
+}
+
+// inferGuesses are tried in order, so that the variables of the usual log
+// formats are preferred.
+var inferGuesses = []inferGuess{
+	{"time_local", true, grammarTest("time_local")},
+	{"time_iso8601", true, grammarTest("time_iso8601")},
+	{"msec", true, func(value string) bool {
+		return strings.IndexByte(value, '.') >= 10 && grammarTest("msec")(value)
+	}},
+	{"request", true, func(value string) bool { return ParseRequestLine(value).Valid }},
+	{"remote_addr", true, grammarTest("remote_addr")},
+	{"http_x_forwarded_for", true, func(value string) bool {
+		for _, addr := range strings.Split(value, upstreamSep) {
+			if !grammarTest("remote_addr")(addr) {
+				return false
+			}
+		}
+		return true
+	}},
+	{"upstream_addr", true, func(value string) bool {
+		for _, group := range strings.Split(value, upstreamGroupSep) {
+			for _, addr := range strings.Split(group, upstreamSep) {
+				i := strings.LastIndexByte(addr, ':')
+				if i < 0 || !grammarTest("remote_addr")(strings.Trim(addr[:i], "[]")) || !grammarTest("server_port")(addr[i+1:]) {
+					return false
+				}
+			}
+		}
+		return true
+	}},
+	{"status", true, grammarTest("status")},
+	{"request_time", true, secondsTest("request_time")},
+	{"upstream_response_time", true, secondsTest("upstream_response_time")},
+	{"upstream_connect_time", true, secondsTest("upstream_connect_time")},
+	{"upstream_header_time", true, secondsTest("upstream_header_time")},
+	{"body_bytes_sent", true, grammarTest("body_bytes_sent")},
+	{"bytes_sent", true, grammarTest("bytes_sent")},
+	{"request_length", true, grammarTest("request_length")},
+	{"host", true, func(value string) bool {
+		return strings.IndexByte(value, '.') >= 0 && grammarTest("host")(value)
+	}},
+	{"http_referer", false, func(value string) bool { return strings.Contains(value, "://") }},
+	{"http_user_agent", false, func(value string) bool { return true }},
+	{"remote_user", false, func(value string) bool { return true }},
+}
+
+// inferUntyped lists the variables without a grammar that may be bound to a
+// field, by kind of field, in the order they are tried for empty fields.
+var inferUntyped = map[byte][]string{
+	'"': {"http_referer", "http_user_agent", "http_x_forwarded_for"},
+	0:   {"remote_user"},
+}
+
+func grammarTest(name string) func(string) bool {
+	g := LookupVariable(name).grammar
+	return func(value string) bool {
+		return g.match([]byte(value))
+	}
+}
+
+// secondsTest only accepts values with a fractional part, leaving integers to
+// byte counts.
+func secondsTest(name string) func(string) bool {
+	v := LookupVariable(name)
+	return func(value string) bool {
+		return strings.IndexByte(value, '.') >= 0 && v.Validate(value) == nil
+	}
+}
+
+// InferLogFormat guesses the log format of sample lines, in the syntax
+// accepted by Compile. Lines are split into quoted, bracketed and blank
+// separated values. Values that are the same in every line are taken as
+// literals unless they look like the value of a known variable, and the
+// others are bound to the first variable of the catalog they are valid for,
+// such as $remote_addr, $time_local, $request or $status, or to the key
+// before them in JSON lines. Values that cannot be told apart are bound to
+// $field1, $field2, and so on, to be renamed.
+//
+// The format is inferred from the lines of the most common layout, and
+// decodes all of them. The more varied the samples, the better the guess.
+func InferLogFormat(samples []string) (string, error) {
+	lines, lits, fields := alignSamples(samples)
+	if len(lines) == 0 {
+		return "", fmt.Errorf("%w: no sample lines", ErrCannotInfer)
+	}
+	esc := EscDefault
+	if strings.HasPrefix(lits[0], "{") && strings.HasSuffix(lits[len(lits)-1], "}") {
+		esc = EscJson
+	}
+
+	var b strings.Builder
+	if esc == EscJson {
+		b.WriteString("escape=json;")
+	}
+	used := make(map[string]bool)
+	lit, last := lits[0], ""
+	for i, f := range fields {
+		name := ""
+		switch {
+		case esc == EscJson && f.kind == '"' && strings.HasPrefix(lits[i+1], `":`):
+			// a key
+		case last == "remote_addr" && f.kind == 0 && isConstant(f.values, "-"):
+			// nginx's own formats write a literal "-" after $remote_addr
+		default:
+			name = guessVariable(f, esc, lit, used)
+		}
+		if name == "" {
+			lit += f.values[0] + lits[i+1]
+			last = ""
+			continue
+		}
+		used[name] = true
+		b.WriteString(strings.Replace(lit, "$", "$$", -1))
+		b.WriteString("$" + name)
+		lit, last = lits[i+1], name
+	}
+	b.WriteString(strings.Replace(lit, "$", "$$", -1))
+	logfmt := b.String()
+
+	ngx, err := Compile(logfmt)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrCannotInfer, err)
+	}
+	for _, line := range lines {
+		if err := ngx.validate([]byte(line), true); err != nil {
+			return "", fmt.Errorf("%w: %s does not decode %q: %v", ErrCannotInfer, logfmt, line, err)
+		}
+	}
+	return logfmt, nil
+}
+
+// guessVariable returns the name of the variable bound to f, which follows
+// the literal lit, or "" if f is a literal.
+func guessVariable(f inferField, esc Esc, lit string, used map[string]bool) string {
+	var values []string
+	for _, value := range f.values {
+		if value != "" && value != "-" && value != esc.Nil() {
+			values = append(values, value)
+		}
+	}
+	constant := len(f.values) > 1 && isConstant(f.values, f.values[0])
+
+	if len(values) == 0 {
+		for _, name := range inferUntyped[f.kind] {
+			if !used[name] {
+				return name
+			}
+		}
+		return fieldName(used)
+	}
+	if key := jsonKey(lit); key != "" && !used[key] {
+		if v := LookupVariable(key); v != nil && all(values, func(value string) bool { return v.Validate(value) == nil }) {
+			return key
+		}
+	}
+	for _, g := range inferGuesses {
+		if used[g.name] || (!g.typed && !contains(inferUntyped[f.kind], g.name)) || !all(values, g.test) {
+			continue
+		}
+		if constant && !g.typed {
+			return ""
+		}
+		return g.name
+	}
+	if constant {
+		return ""
+	}
+	return fieldName(used)
+}
+
+func all(values []string, test func(string) bool) bool {
+	for _, value := range values {
+		if !test(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isConstant(values []string, value string) bool {
+	for _, v := range values {
+		if v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldName returns the first unused name of the form field1, field2...
+func fieldName(used map[string]bool) string {
+	for n := 1; ; n++ {
+		if name := fmt.Sprintf("field%d", n); !used[name] {
+			return name
+		}
+	}
+}
+
+// jsonKey returns the key lit ends with, as in {"key":" or ,"key":, or "".
+func jsonKey(lit string) string {
+	lit = strings.TrimSuffix(lit, `"`)
+	if !strings.HasSuffix(lit, `":`) {
+		return ""
+	}
+	lit = lit[:len(lit)-2]
+	i := strings.LastIndexByte(lit, '"')
+	if i < 0 {
+		return ""
+	}
+	key := lit[i+1:]
+	for j := 0; j < len(key); j++ {
+		if !isVarChar(key[j]) || key[j] == '.' {
+			return ""
+		}
+	}
+	return key
+}
+
+// alignSamples returns the sample lines of the most common layout, along with
+// the literals around their values and the values found at each place.
+func alignSamples(samples []string) ([]string, []string, []inferField) {
+	type layout struct {
+		lines  []string
+		lits   []string
+		values [][]sampleValue
+	}
+	var (
+		layouts []*layout
+		byKey   = make(map[string]*layout)
+	)
+	for _, line := range samples {
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+		lits, values, ok := tokenizeSample(line)
+		if !ok {
+			continue
+		}
+		key := strings.Join(lits, "\x00")
+		for _, v := range values {
+			key += string(v.kind) + "\x01"
+		}
+		l := byKey[key]
+		if l == nil {
+			l = &layout{lits: lits}
+			byKey[key] = l
+			layouts = append(layouts, l)
+		}
+		l.lines = append(l.lines, line)
+		l.values = append(l.values, values)
+	}
+
+	var best *layout
+	for _, l := range layouts {
+		if best == nil || len(l.lines) > len(best.lines) {
+			best = l
+		}
+	}
+	if best == nil {
+		return nil, nil, nil
+	}
+	fields := make([]inferField, len(best.values[0]))
+	for i := range fields {
+		fields[i].kind = best.values[0][i].kind
+		for _, values := range best.values {
+			fields[i].values = append(fields[i].values, values[i].value)
+		}
+	}
+	return best.lines, best.lits, fields
+}
+
+// tokenizeSample splits line into its values and the literals around them,
+// quotes and brackets included, so that len(lits) is len(values)+1. Values
+// are quoted, bracketed, or runs of bytes up to a blank, a quote, '|', '}', or
+// a ',' followed by a quote.
+func tokenizeSample(line string) (lits []string, values []sampleValue, ok bool) {
+	var lit strings.Builder
+	for p := 0; p < len(line); {
+		switch ch := line[p]; ch {
+		case '"', '[':
+			end := p + 1
+			if ch == '"' {
+				for end < len(line) && line[end] != '"' {
+					if line[end] == '\\' {
+						end++
+					}
+					end++
+				}
+			} else {
+				for end < len(line) && line[end] != ']' {
+					end++
+				}
+			}
+			if end >= len(line) {
+				return nil, nil, false
+			}
+			lit.WriteByte(ch)
+			lits = append(lits, lit.String())
+			values = append(values, sampleValue{ch, line[p+1 : end]})
+			lit.Reset()
+			lit.WriteByte(line[end])
+			p = end + 1
+		case ' ', '\t', '|', '{', '}', ',', ':', ';':
+			lit.WriteByte(ch)
+			p++
+		default:
+			end := p
+			for end < len(line) && !strings.ContainsRune(" \t\"|}", rune(line[end])) &&
+				!(line[end] == ',' && end+1 < len(line) && line[end+1] == '"') {
+				end++
+			}
+			lits = append(lits, lit.String())
+			values = append(values, sampleValue{0, line[p:end]})
+			lit.Reset()
+			p = end
+		}
+	}
+	return append(lits, lit.String()), values, true
+}
+
+func isVarChar(ch byte) bool {
+	return (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') || ch == '_' || ch == '.'
+}
@@ -0,0 +1,53 @@
+package ngx
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInferLogFormat(t *testing.T) {
+	for _, tc := range []struct {
+		Samples  []string
+		Expected string
+	}{
+		{
+			[]string{
+				`127.0.0.1 - - [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 200 612 "-" "curl/7.68.0"`,
+				`10.1.2.3 - frank [10/Oct/2020:13:55:37 +0000] "POST /login?next=%2F HTTP/1.1" 302 0 "https://example.com/" "Mozilla/5.0 (X11; Linux x86_64)"`,
+				`::1 - - [10/Oct/2020:13:56:01 +0000] "GET /favicon.ico HTTP/2.0" 404 153 "-" "Mozilla/5.0 (Macintosh)"`,
+			},
+			`$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+		},
+		{
+			[]string{
+				`127.0.0.1 - - [10/Oct/2020:13:55:36 +0000] "GET / HTTP/1.1" 200 612 "-" "curl/7.68.0" "-" 0.004`,
+				`127.0.0.1 - - [10/Oct/2020:06:55:37 -0700] "GET /a HTTP/1.1" 200 99 "-" "Wget/1.20.3" "10.0.0.1, 10.0.0.2" 0.120`,
+			},
+			`$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" "$http_x_forwarded_for" $request_time`,
+		},
+		{
+			[]string{
+				`{"status":"200","host":"example.com","ts":"2020-10-10T13:55:36+00:00","rt":0.002,"up":"10.0.0.1:80"}`,
+				`{"status":"404","host":"www.example.com","ts":"2020-10-10T08:55:37-05:00","rt":0.013,"up":"10.0.0.2:80, 10.0.0.3:80"}`,
+				`not json`,
+			},
+			`escape=json;{"status":"$status","host":"$host","ts":"$time_iso8601","rt":$request_time,"up":"$upstream_addr"}`,
+		},
+		{
+			[]string{`a|b $$ c`, `d|b $$ e`},
+			`$remote_user|b $$$$ $field1`,
+		},
+	} {
+		logfmt, err := InferLogFormat(tc.Samples)
+		if err != nil {
+			t.Fatalf("unexpected error inferring %q: %s", tc.Samples, err)
+		}
+		if logfmt != tc.Expected {
+			t.Fatalf("unexpected log format inferred from %q:\nexpecting %s\ngot       %s", tc.Samples, tc.Expected, logfmt)
+		}
+	}
+
+	if _, err := InferLogFormat([]string{"", `"unterminated`}); !errors.Is(err, ErrCannotInfer) {
+		t.Fatalf("unexpected error inferring nothing: %v", err)
+	}
+}
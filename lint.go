@@ -0,0 +1,116 @@
+package ngx
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A Warning reports a part of a log format that may not decode reliably.
+type Warning struct {
+	Op       int    // index of the variable in the log format
+	Variable string // name of the variable
+	// Check is the kind of problem found: "delimiter" if the literal after
+	// the variable may appear in its value, "adjacent" if the variable is
+	// directly followed by another one, "duplicate" if the variable already
+	// appears earlier, "trailing" if it ends the format and takes the rest
+	// of the line whatever it looks like.
+	Check   string
+	Message string
+	Fix     string // suggested change to the log format
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("$%s: %s (fix: %s)", w.Variable, w.Message, w.Fix)
+}
+
+// Lint reports the variables of the log format that may not decode reliably,
+// given its escaping and what the catalog knows about their values.
+func (ngx *NGX) Lint() []Warning {
+	var warnings []Warning
+	seen := make(map[string]bool)
+	for i, op := range ngx.ops {
+		if op.Type != ngxVariable {
+			continue
+		}
+		name := string(op.Extra)
+		if seen[name] {
+			warnings = append(warnings, Warning{
+				Op:       i,
+				Variable: name,
+				Check:    "duplicate",
+				Message:  "variable appears more than once, only its last occurrence is decoded",
+				Fix:      fmt.Sprintf("remove all but one $%s", name),
+			})
+		}
+		seen[name] = true
+
+		if i+1 >= len(ngx.ops) {
+			if g := grammarOf(LookupVariable(name)); g == nil || !g.bounded {
+				warnings = append(warnings, Warning{
+					Op:       i,
+					Variable: name,
+					Check:    "trailing",
+					Message:  "value ends the line, so it takes whatever follows it",
+					Fix:      fmt.Sprintf("end the log format with a literal, e.g. \"$%s\"", name),
+				})
+			}
+			continue
+		}
+
+		next := ngx.ops[i+1]
+		if next.Type == ngxVariable {
+			warnings = append(warnings, Warning{
+				Op:       i,
+				Variable: name,
+				Check:    "adjacent",
+				Message:  fmt.Sprintf("value is not separated from $%s, decoding guesses where it ends", next.Extra),
+				Fix:      fmt.Sprintf("separate them with a literal, e.g. $%s $%s", name, next.Extra),
+			})
+			continue
+		}
+		if !ngx.delimits(name, next.Extra) {
+			fix := fmt.Sprintf("quote the variable, e.g. \"$%s\"", name)
+			if ngx.esc == EscNone {
+				fix = fmt.Sprintf("use escape=default and quote the variable, e.g. \"$%s\"", name)
+			}
+			warnings = append(warnings, Warning{
+				Op:       i,
+				Variable: name,
+				Check:    "delimiter",
+				Message:  fmt.Sprintf("value may contain %q, which ends it", next.Extra),
+				Fix:      fix,
+			})
+		}
+	}
+	return warnings
+}
+
+// delimits reports whether literal never appears in the value of the variable
+// name, either because nginx escapes one of its bytes, or because the value
+// cannot contain one of them.
+func (ngx *NGX) delimits(name string, literal []byte) bool {
+	if ngx.esc != EscNone {
+		if bytes.IndexByte(literal, '"') >= 0 {
+			return true
+		}
+		for _, ch := range literal {
+			if ch < 0x20 {
+				return true
+			}
+		}
+	}
+
+	v := LookupVariable(name)
+	if v == nil || v.grammar == nil {
+		return false
+	}
+	if v.list && bytes.ContainsAny(literal, ",:") {
+		return false
+	}
+	for _, ch := range literal {
+		if !v.grammar.chars(ch) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package ngx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	for _, tc := range []struct {
+		Fmt      string
+		Expected []string // check:variable
+	}{
+		{`escape=none;$http_user_agent $status`, []string{"delimiter:http_user_agent"}},
+		{`"$http_user_agent" $status`, nil},
+		{`$remote_addr $status $request_time [$time_local]`, nil},
+		{`$upstream_addr $upstream_status "$request"`, []string{"delimiter:upstream_addr"}},
+		{`$scheme$host "$request"`, []string{"adjacent:scheme"}},
+		{`$status "$request" $status`, []string{"duplicate:status"}},
+		{`$status "$request" $http_user_agent`, []string{"trailing:http_user_agent"}},
+	} {
+		ngx, err := Compile(tc.Fmt)
+		if err != nil {
+			t.Fatalf("failed to Compile() format %q: %v", tc.Fmt, err)
+		}
+		var got []string
+		for _, w := range ngx.Lint() {
+			got = append(got, w.Check+":"+w.Variable)
+			if w.Fix == "" {
+				t.Fatalf("expecting a fix in %+v", w)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.Expected) {
+			t.Fatalf("unexpected warnings on %q: expecting %q, got %q", tc.Fmt, tc.Expected, got)
+		}
+	}
+}
@@ -2,8 +2,11 @@ package ngx
 
 import (
 	"errors"
+	"io"
 	"reflect"
+	"strings"
 	"sync"
+	"unsafe"
 
 	"github.com/modern-go/reflect2"
 )
@@ -37,6 +40,10 @@ func Marshal(v interface{}) ([]byte, error) {
 	return ngx.Marshal(v)
 }
 
+func AppendMarshal(dst []byte, v interface{}) ([]byte, error) {
+	return ngx.AppendMarshal(dst, v)
+}
+
 func MarshalToString(v interface{}) (string, error) {
 	return ngx.MarshalToString(v)
 }
@@ -49,6 +56,14 @@ func UnmarshalFromString(str string, v interface{}) error {
 	return ngx.UnmarshalFromString(str, v)
 }
 
+func NewDecoder(r io.Reader) *Decoder {
+	return ngx.NewDecoder(r)
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return ngx.NewEncoder(w)
+}
+
 func Supported() map[string]int {
 	return ngx.supported
 }
@@ -57,9 +72,11 @@ func Supported() map[string]int {
 
 type NGX struct {
 	cache     sync.Map
+	src       string
 	ops       []baseOp
 	esc       Esc
 	supported map[string]int
+	opts      Options
 }
 
 func (ngx *NGX) MarshalToString(itf interface{}) (string, error) {
@@ -67,37 +84,9 @@ func (ngx *NGX) MarshalToString(itf interface{}) (string, error) {
 		return "", nil
 	}
 
-	ptr := reflect2.PtrOf(itf)
-
-	rtyp := reflect2.RTypeOf(itf)
-
 	w := AcquireWriter()
-
-	if codec, _ := ngx.cache.Load(rtyp); codec != nil {
-		if err := codec.(Codec).Encode(ptr, w); err != nil {
-			return "", err
-		}
-		res := w.CopyString()
+	if err := ngx.encode(itf, w); err != nil {
 		ReleaseWriter(w)
-		return res, nil
-	}
-
-	// create codec
-
-	typ := reflect2.TypeOf(itf)
-
-	d, err := codecOf(ngx, typ)
-	if err != nil {
-		return "", err
-	}
-
-	if typ.LikePtr() {
-		d = &refCodec{d}
-	}
-
-	ngx.cache.Store(rtyp, d)
-
-	if err := d.Encode(ptr, w); err != nil {
 		return "", err
 	}
 	res := w.CopyString()
@@ -110,110 +99,207 @@ func (ngx *NGX) Marshal(itf interface{}) ([]byte, error) {
 		return nil, nil
 	}
 
-	ptr := reflect2.PtrOf(itf)
-
-	rtyp := reflect2.RTypeOf(itf)
-
 	w := AcquireWriter()
-
-	if codec, _ := ngx.cache.Load(rtyp); codec != nil {
-		if err := codec.(Codec).Encode(ptr, w); err != nil {
-			return nil, err
-		}
-		res := w.CopyBytes()
+	if err := ngx.encode(itf, w); err != nil {
 		ReleaseWriter(w)
-		return res, nil
+		return nil, err
 	}
+	res := w.CopyBytes()
+	ReleaseWriter(w)
+	return res, nil
+}
 
-	// create codec
-
-	typ := reflect2.TypeOf(itf)
+// AppendMarshal appends the encoding of itf to dst and returns the extended
+// buffer. On error, dst is returned unmodified.
+func (ngx *NGX) AppendMarshal(dst []byte, itf interface{}) ([]byte, error) {
+	if len(ngx.ops) <= 0 {
+		return dst, nil
+	}
 
-	d, err := codecOf(ngx, reflect2.TypeOf(itf))
+	n := len(dst)
+	w := AcquireWriter()
+	buf := w.buf
+	w.buf = dst
+	err := ngx.encode(itf, w)
+	dst, w.buf = w.buf, buf
+	ReleaseWriter(w)
 	if err != nil {
-		return nil, err
+		return dst[:n], err
 	}
+	return dst, nil
+}
 
-	if typ.LikePtr() {
-		d = &refCodec{d}
+// UnmarshalFromString decodes a line into the value itf points to. Decoded
+// strings share memory with data, so that decoding into a struct of strings
+// and numbers does not allocate.
+func (ngx *NGX) UnmarshalFromString(data string, itf interface{}) error {
+	if len(ngx.ops) <= 0 {
+		return nil
 	}
 
-	ngx.cache.Store(rtyp, d)
-
-	if err := d.Encode(ptr, w); err != nil {
-		return nil, err
-	}
-	res := w.CopyBytes()
-	ReleaseWriter(w)
-	return res, nil
+	st := acquireState(0)
+	st.str = StringReader{data, len(data)}
+	err := ngx.decode(itf, &st.str)
+	releaseState(st)
+	return err
 }
 
-func (ngx *NGX) UnmarshalFromString(data string, itf interface{}) error {
+// Unmarshal decodes a line into the value itf points to. Decoded strings are
+// copied out of data, which the caller may reuse, at the cost of an
+// allocation per non-empty string: use UnmarshalFromString to decode without
+// allocating.
+func (ngx *NGX) Unmarshal(data []byte, itf interface{}) error {
 	if len(ngx.ops) <= 0 {
 		return nil
 	}
 
+	st := acquireState(0)
+	st.bytes.buf = data
+	err := ngx.decode(itf, &st.bytes)
+	releaseState(st)
+	return err
+}
+
+func (ngx *NGX) encode(itf interface{}, w Writer) error {
 	ptr := reflect2.PtrOf(itf)
-	if ptr == nil {
-		return ErrNilPointer
-	}
 
-	rtyp := reflect2.RTypeOf(itf)
+	rtyp := rtypeOf(itf)
 
-	if codec, _ := ngx.cache.Load(rtyp); codec != nil {
-		return codec.(Codec).Decode(ptr, NewStringReader(data))
+	codec, _ := ngx.cache.Load(rtyp)
+	if codec == nil {
+		d, err := ngx.newCodec(rtyp, reflect2.TypeOf(itf))
+		if err != nil {
+			return err
+		}
+		codec = d
 	}
 
-	// create codec
-
-	typ := reflect2.TypeOf(itf)
-	if typ.Kind() != reflect.Ptr {
-		return ErrNonPointer
+	if ptr == nil {
+		w.WriteString(ngx.esc.Nil())
+		return nil
 	}
+	return codec.(Codec).Encode(ptr, w)
+}
 
-	d, err := codecOf(ngx, typ.(*reflect2.UnsafePtrType).Elem())
+func (ngx *NGX) decode(itf interface{}, r Reader) error {
+	codec, ptr, err := ngx.decoderOf(itf)
 	if err != nil {
 		return err
 	}
-
-	ngx.cache.Store(rtyp, d)
-
-	return d.Decode(ptr, NewStringReader(data))
+	return codec.Decode(ptr, r)
 }
 
-func (ngx *NGX) Unmarshal(data []byte, itf interface{}) error {
-	if len(ngx.ops) <= 0 {
-		return nil
-	}
-
+func (ngx *NGX) decoderOf(itf interface{}) (Codec, unsafe.Pointer, error) {
 	ptr := reflect2.PtrOf(itf)
 	if ptr == nil {
-		return ErrNilPointer
+		return nil, nil, ErrNilPointer
 	}
 
-	rtyp := reflect2.RTypeOf(itf)
+	rtyp := rtypeOf(itf)
 
 	if codec, _ := ngx.cache.Load(rtyp); codec != nil {
-		return codec.(Codec).Decode(ptr, NewBytesReader(data))
+		return codec.(Codec), ptr, nil
 	}
 
-	// create codec
-
 	typ := reflect2.TypeOf(itf)
 	if typ.Kind() != reflect.Ptr {
-		return ErrNonPointer
+		return nil, nil, ErrNonPointer
 	}
 
-	d, err := codecOf(ngx, typ.(*reflect2.UnsafePtrType).Elem())
+	d, err := ngx.newCodec(rtyp, typ)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	return d, ptr, nil
+}
+
+// newCodec creates the codec for values of type typ and caches it under rtyp,
+// the type word of typ. Pointers share the same codec for encoding and
+// decoding, which works on the pointed-to value.
+func (ngx *NGX) newCodec(rtyp unsafe.Pointer, typ reflect2.Type) (Codec, error) {
+	var (
+		d   Codec
+		err error
+	)
+
+	if typ.Kind() == reflect.Ptr {
+		d, err = codecOfRoot(ngx, typ.(*reflect2.UnsafePtrType).Elem())
+	} else if d, err = codecOfRoot(ngx, typ); err == nil && typ.LikePtr() {
+		d = &refCodec{d}
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	ngx.cache.Store(rtyp, d)
+	return d, nil
+}
 
-	return d.Decode(ptr, NewBytesReader(data))
+// rtypeOf returns the type word of itf, which keys the codec cache without
+// allocating, unlike the uintptr of reflect2.RTypeOf.
+func rtypeOf(itf interface{}) unsafe.Pointer {
+	return (*[2]unsafe.Pointer)(unsafe.Pointer(&itf))[0]
 }
 
 func (ngx *NGX) Supported() map[string]int {
 	return ngx.supported
 }
+
+// lookupVar returns the name of the variable called name, or the prefix of
+// the variables starting with name if it ends with a dot. If fold is true and
+// nothing matches exactly, case is ignored, the first variable of the log
+// format that matches winning.
+func (ngx *NGX) lookupVar(name string, fold bool) (string, bool) {
+	if _, ok := ngx.supported[name]; ok {
+		return name, true
+	}
+	exact := func(a, b string) bool { return a == b }
+	if varname, ok := ngx.findVar(name, exact); ok || !fold {
+		return varname, ok
+	}
+	return ngx.findVar(name, strings.EqualFold)
+}
+
+// findVar returns the name, or the prefix if name ends with a dot, of the
+// first variable of the log format that equal reports to be name.
+func (ngx *NGX) findVar(name string, equal func(a, b string) bool) (string, bool) {
+	dotted := strings.HasSuffix(name, ".")
+	for _, op := range ngx.ops {
+		varname := string(op.Extra)
+		if op.Type != ngxVariable || len(varname) < len(name) {
+			continue
+		}
+		if dotted && len(varname) > len(name) {
+			varname = varname[:len(name)]
+		}
+		if equal(varname, name) {
+			return varname, true
+		}
+	}
+	return "", false
+}
+
+// A FormatPart is a literal or a variable of a log format.
+type FormatPart struct {
+	Literal  string // text of a literal, empty for variables
+	Variable string // name of a variable, empty for literals
+}
+
+// Parts returns the literals and variables of the log format, in order.
+func (ngx *NGX) Parts() []FormatPart {
+	parts := make([]FormatPart, len(ngx.ops))
+	for i, op := range ngx.ops {
+		if op.Type == ngxVariable {
+			parts[i].Variable = string(op.Extra)
+		} else {
+			parts[i].Literal = string(op.Extra)
+		}
+	}
+	return parts
+}
+
+// Escaping returns how the log format escapes the values of variables.
+func (ngx *NGX) Escaping() Esc {
+	return ngx.esc
+}
@@ -147,3 +147,62 @@ func BenchmarkUnmarshal(b *testing.B) {
 		}
 	}
 }
+
+const accessLine = `127.0.0.1 - frank [10/Oct/2020:13:55:36 +0000] "GET /index.html HTTP/1.1" 200 2326 "http://example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`
+
+func BenchmarkUnmarshalAccess(b *testing.B) {
+	data := []byte(accessLine)
+	b.ReportAllocs()
+	var v Access
+	for i := 0; i < b.N; i++ {
+		if err := Unmarshal(data, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalAccessFromString(b *testing.B) {
+	b.ReportAllocs()
+	var v Access
+	for i := 0; i < b.N; i++ {
+		if err := UnmarshalFromString(accessLine, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestUnmarshalAllocs(t *testing.T) {
+	var v Access
+	line := []byte(accessLine)
+	if raceEnabled {
+		t.Log("skipping allocation counts with the race detector")
+	} else {
+		if allocs := testing.AllocsPerRun(100, func() {
+			if err := UnmarshalFromString(accessLine, &v); err != nil {
+				t.Fatal(err)
+			}
+		}); allocs != 0 {
+			t.Errorf("UnmarshalFromString allocates %v times, want 0", allocs)
+		}
+		// strings are copied out of bytes, one allocation per string value
+		if allocs := testing.AllocsPerRun(100, func() {
+			if err := Unmarshal(line, &v); err != nil {
+				t.Fatal(err)
+			}
+		}); allocs != 6 {
+			t.Errorf("Unmarshal allocates %v times, want 6", allocs)
+		}
+	}
+
+	// values decoded from bytes must not share memory with them
+	data := []byte(accessLine)
+	if err := Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	for i := range data {
+		data[i] = 'x'
+	}
+	if v.RemoteUser != "frank" || v.HTTPReferer != "http://example.com/start.html" {
+		t.Errorf("values changed with the line: %+v", v)
+	}
+}
@@ -0,0 +1,38 @@
+package ngx
+
+// Options change how an NGX decodes log lines.
+type Options struct {
+	// Lenient keeps decoding the other values of a line after one of them
+	// fails to decode. The failed struct field is left at its zero value,
+	// the failed map element is not set, and the error returned is a
+	// FieldErrors listing every failure along with its raw text, see
+	// FieldError.Raw. Lines that do not match the log format still fail as
+	// a whole.
+	Lenient bool
+
+	// Backtrack makes decoding try every place a value may end, instead of
+	// the first occurrence of the literal that follows it, until the whole
+	// line matches and every value decodes and is valid for its variable
+	// (see Variable.Validate). It is meant for formats whose values may
+	// contain their delimiter, such as escape=none formats.
+	Backtrack bool
+	// MaxBacktrack bounds the number of places tried for a line, 4096 if
+	// zero. Lines needing more fail with ErrBacktrackLimit.
+	MaxBacktrack int
+}
+
+// WithOptions returns a copy of ngx decoding with opts.
+func (ngx *NGX) WithOptions(opts Options) *NGX {
+	return &NGX{
+		src:       ngx.src,
+		ops:       ngx.ops,
+		esc:       ngx.esc,
+		supported: ngx.supported,
+		opts:      opts,
+	}
+}
+
+// Options returns the options ngx decodes with.
+func (ngx *NGX) Options() Options {
+	return ngx.opts
+}
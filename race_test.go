@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package ngx
+
+// raceEnabled skips allocation counts, sync.Pool drops items under the race
+// detector.
+const raceEnabled = true
@@ -0,0 +1,94 @@
+package ngx
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"unsafe"
+)
+
+var requestLineType = reflect.TypeOf(RequestLine{})
+
+// RequestLine is the request line logged by $request, such as
+// "GET /index.html HTTP/1.1". It can be bound to $request instead of a string.
+type RequestLine struct {
+	Method   string
+	URI      string   // as sent by the client
+	URL      *url.URL // URI parsed, nil if it does not parse
+	Protocol string
+	// Valid reports whether the line is made of a method, a URI and a
+	// protocol. Lines of garbage such as a TLS handshake sent to a plain
+	// HTTP port are not valid, and keep whatever parts could be found.
+	Valid bool
+	// Raw is the line as logged, written back by Marshal. If Raw is empty,
+	// the line is made of Method, URI and Protocol.
+	Raw string
+}
+
+// ParseRequestLine splits line into its method, URI and protocol.
+func ParseRequestLine(line string) RequestLine {
+	r := RequestLine{Raw: line}
+	parts := strings.SplitN(line, " ", 3)
+	r.Method = parts[0]
+	if len(parts) > 1 {
+		r.URI = parts[1]
+		if u, err := url.ParseRequestURI(r.URI); err == nil {
+			r.URL = u
+		}
+	}
+	if len(parts) > 2 {
+		r.Protocol = parts[2]
+	}
+	r.Valid = len(parts) == 3 && isMethod(r.Method) && len(r.URI) > 0 && isProtocol(r.Protocol)
+	return r
+}
+
+// String returns the request line.
+func (r *RequestLine) String() string {
+	if r.Raw != "" {
+		return r.Raw
+	}
+	if r.Method == "" && r.URI == "" && r.Protocol == "" {
+		return ""
+	}
+	s := r.Method + " " + r.URI
+	if r.Protocol != "" {
+		s += " " + r.Protocol
+	}
+	return s
+}
+
+func isMethod(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isUpper(s[i]) && s[i] != '_' && s[i] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isProtocol(s string) bool {
+	return grammarProtocol.match([]byte(s)) && s != "-"
+}
+
+type requestLineCodec struct {
+	esc Esc
+}
+
+func (d *requestLineCodec) Encode(ptr unsafe.Pointer, text Writer) error {
+	line := (*RequestLine)(ptr).String()
+	if line == "" {
+		text.WriteString(d.esc.Nil())
+		return nil
+	}
+	_, err := text.Write(d.esc.Escape(NewStringReader(line).Bytes()))
+	return err
+}
+
+func (d *requestLineCodec) Decode(ptr unsafe.Pointer, text Reader) error {
+	*(*RequestLine)(ptr) = ParseRequestLine(text.NewString())
+	return nil
+}
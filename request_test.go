@@ -0,0 +1,42 @@
+package ngx
+
+import "testing"
+
+func TestParseRequestLine(t *testing.T) {
+	for _, tc := range []struct {
+		Line     string
+		Method   string
+		URI      string
+		Path     string
+		Protocol string
+		Valid    bool
+	}{
+		{`GET /index.html?q=1 HTTP/1.1`, "GET", "/index.html?q=1", "/index.html", "HTTP/1.1", true},
+		{`CONNECT example.com:443 HTTP/1.1`, "CONNECT", "example.com:443", "", "HTTP/1.1", true},
+		{`GET /`, "GET", "/", "/", "", false},
+		{`GET / HTTP/1.1 junk`, "GET", "/", "/", "HTTP/1.1 junk", false},
+		{`get / HTTP/1.1`, "get", "/", "/", "HTTP/1.1", false},
+		{"\x16\x03\x01\x02\x00\x01", "\x16\x03\x01\x02\x00\x01", "", "", "", false},
+		{``, "", "", "", "", false},
+	} {
+		r := ParseRequestLine(tc.Line)
+		if r.Method != tc.Method || r.URI != tc.URI || r.Protocol != tc.Protocol || r.Valid != tc.Valid || r.Raw != tc.Line {
+			t.Fatalf("unexpected request line from %q: %+v", tc.Line, r)
+		}
+		path := ""
+		if r.URL != nil {
+			path = r.URL.Path
+		}
+		if path != tc.Path {
+			t.Fatalf("unexpected path from %q: expecting %q, got %q", tc.Line, tc.Path, path)
+		}
+		if r.String() != tc.Line {
+			t.Fatalf("expecting String() to return %q, got %q", tc.Line, r.String())
+		}
+	}
+
+	r := RequestLine{Method: "GET", URI: "/", Protocol: "HTTP/1.0"}
+	if r.String() != "GET / HTTP/1.0" {
+		t.Fatalf("unexpected String() %q", r.String())
+	}
+}
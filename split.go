@@ -0,0 +1,332 @@
+package ngx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/modern-go/reflect2"
+)
+
+// A span locates the raw value of a variable in a line.
+type span struct {
+	start, end int
+}
+
+// split matches data against ngx.ops, recording in spans[i] where the raw
+// value of each variable ops[i] starts and ends.
+func (ngx *NGX) split(data []byte, spans []span) error {
+	p := 0
+	ops := ngx.ops
+	length := len(ops)
+	for i := 0; i < length; i++ {
+		op := ops[i]
+		switch op.Type {
+		case ngxString, ngxEscString:
+			if !bytes.HasPrefix(data[p:], op.Extra) {
+				got := data[p:]
+				if len(got) > len(op.Extra) {
+					got = got[:len(op.Extra)]
+				}
+				return &LiteralMismatchError{Op: i, Offset: p, Expected: string(op.Extra), Got: string(got)}
+			}
+			p += len(op.Extra)
+		case ngxVariable:
+			if i+1 >= length {
+				spans[i] = span{p, len(data)}
+				p = len(data)
+				continue
+			}
+			next := ops[i+1]
+			switch next.Type {
+			case ngxString, ngxEscString:
+				off := indexValue(ngx.esc, op.Extra, data[p:], next.Extra)
+				if off < 0 {
+					return ngx.eofError(data, p, i)
+				}
+				spans[i] = span{p, p + off}
+				i++
+				p += off + len(next.Extra)
+			case ngxVariable:
+				n, err := ngx.splitAdjacent(i, data, p)
+				if err != nil {
+					return err
+				}
+				spans[i] = span{p, p + n}
+				p += n
+			default:
+				return fmt.Errorf("Unsupported operator type(%d)", next.Type)
+			}
+		default:
+			return fmt.Errorf("Unsupported operator type(%d)", op.Type)
+		}
+	}
+	return nil
+}
+
+// end returns where the match of ngx.ops recorded in spans ends in the line.
+func (ngx *NGX) end(spans []span) int {
+	p, i := 0, len(ngx.ops)-1
+	for ; i >= 0 && ngx.ops[i].Type != ngxVariable; i-- {
+		p += len(ngx.ops[i].Extra)
+	}
+	if i >= 0 {
+		p += spans[i].end
+	}
+	return p
+}
+
+func (ngx *NGX) eofError(data []byte, p, i int) *SyntaxError {
+	return &SyntaxError{
+		Msg:      fmt.Sprintf("got unexpected EOF: expecting %q after $%s", ngx.ops[i+1].Extra, ngx.ops[i].Extra),
+		Op:       i,
+		Variable: string(ngx.ops[i].Extra),
+		Offset:   p,
+		Snippet:  snippet(data, p),
+	}
+}
+
+// indexValue returns the length of the raw value of the variable name at the
+// start of data, which ends before the literal delim, or -1.
+func indexValue(esc Esc, name, data, delim []byte) int {
+	if esc.isEscapeChar(delim[0]) {
+		return indexEscaped(esc, data, delim)
+	}
+	if bytes.HasPrefix(name, []byte("upstream_")) {
+		if off := indexUpstream(data, delim); off >= 0 {
+			return off
+		}
+	}
+	return bytes.Index(data, delim)
+}
+
+// indexEscaped returns the index of the first delim in data that is not part
+// of an escape sequence, or -1.
+func indexEscaped(esc Esc, data, delim []byte) int {
+	p := 0
+	for {
+		off := bytes.Index(data[p:], delim)
+		if off < 0 {
+			return -1
+		}
+		off += p
+		if off > 0 && data[off-1] == '\\' {
+			if esc != EscJson {
+				p = off + len(delim)
+				continue
+			}
+			if _, err := esc.Unescape(data[:off]); err != nil {
+				p = off + len(delim)
+				continue
+			}
+		}
+		return off
+	}
+}
+
+// indexUpstream is like bytes.Index, but skips the occurrences of delim that
+// overlap the separators of an $upstream_* list, e.g. the " " in ", ".
+func indexUpstream(data, delim []byte) int {
+	p := 0
+	for {
+		off := bytes.Index(data[p:], delim)
+		if off < 0 {
+			return -1
+		}
+		off += p
+		if !inUpstreamSep(data, off) {
+			return off
+		}
+		p = off + 1
+	}
+}
+
+func inUpstreamSep(data []byte, off int) bool {
+	for _, sep := range [...]string{upstreamSep, upstreamGroupSep} {
+		for k := off - len(sep) + 1; k <= off; k++ {
+			if k >= 0 && bytes.HasPrefix(data[k:], []byte(sep)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitAdjacent returns the length of the value of ops[i] at data[p:], when
+// ops[i+1] is a variable too. It takes the longest value after which the next
+// variable, and whatever follows it, still match. The values of a bounded
+// grammar are alternatives instead, such as "https" and "http", and the line
+// is rejected if more than one of them matches.
+func (ngx *NGX) splitAdjacent(i int, data []byte, p int) (int, error) {
+	ops := ngx.ops
+	a, b := grammarOf(ops[i].Var), grammarOf(ops[i+1].Var)
+	fits := func(n int) bool {
+		if b == nil {
+			return true
+		}
+		rest := data[p+n:]
+		for _, m := range b.lengths(rest) {
+			switch {
+			case i+2 >= len(ops):
+				if m == len(rest) {
+					return true
+				}
+			case ops[i+2].Type == ngxVariable:
+				return true
+			case bytes.HasPrefix(rest[m:], ops[i+2].Extra):
+				return true
+			}
+		}
+		return false
+	}
+	if a != nil {
+		found := -1
+		for _, n := range a.lengths(data[p:]) {
+			if !fits(n) {
+				continue
+			}
+			if !a.bounded {
+				return n, nil
+			}
+			if found >= 0 {
+				return 0, &SyntaxError{
+					Msg:      fmt.Sprintf("%q splits into $%s and $%s in more than one way", snippet(data, p), ops[i].Extra, ops[i+1].Extra),
+					Op:       i,
+					Variable: string(ops[i].Extra),
+					Offset:   p,
+					Snippet:  snippet(data, p),
+				}
+			}
+			found = n
+		}
+		if found >= 0 {
+			return found, nil
+		}
+	}
+	return 0, &SyntaxError{
+		Msg:      fmt.Sprintf("cannot split %q into $%s and $%s", snippet(data, p), ops[i].Extra, ops[i+1].Extra),
+		Op:       i,
+		Variable: string(ops[i].Extra),
+		Offset:   p,
+		Snippet:  snippet(data, p),
+	}
+}
+
+const defaultMaxBacktrack = 4096
+
+var ErrBacktrackLimit = errors.New("Backtracking limit exceeded")
+
+// A checker tells splitWith whether raw values decode, from the codec and the
+// type of the value bound to each op, or a nil codec for unbound ones.
+type checker []checkOp
+
+type checkOp struct {
+	codec Codec
+	typ   reflect2.Type
+}
+
+// newChecker returns the checker of n ops, whose bound values bound returns.
+func newChecker(n int, bound func(i int) (Codec, reflect2.Type)) checker {
+	c := make(checker, n)
+	for i := range c {
+		c[i].codec, c[i].typ = bound(i)
+	}
+	return c
+}
+
+// check reports whether raw decodes into the value bound to ops[i].
+func (c checker) check(i int, raw []byte) bool {
+	op := c[i]
+	return op.codec == nil || op.codec.Decode(op.typ.UnsafeNew(), NewBytesReader(raw)) == nil
+}
+
+// splitWith is split, backtracking if enabled. check reports whether raw, the
+// unescaped value of the variable ops[i], decodes.
+func (ngx *NGX) splitWith(data []byte, spans []span, check func(i int, raw []byte) bool) error {
+	if !ngx.opts.Backtrack {
+		return ngx.split(data, spans)
+	}
+	budget := ngx.opts.MaxBacktrack
+	if budget <= 0 {
+		budget = defaultMaxBacktrack
+	}
+	b := backtracker{ngx, data, spans, check, budget}
+	ok, err := b.match(0, 0)
+	if err != nil || ok {
+		return err
+	}
+	// report where the first match fails
+	return ngx.split(data, spans)
+}
+
+type backtracker struct {
+	ngx    *NGX
+	data   []byte
+	spans  []span
+	check  func(i int, raw []byte) bool
+	budget int
+}
+
+// match reports whether data[p:] matches ngx.ops[i:].
+func (b *backtracker) match(i, p int) (bool, error) {
+	ops := b.ngx.ops
+	for ; i < len(ops) && ops[i].Type != ngxVariable; i++ {
+		if !bytes.HasPrefix(b.data[p:], ops[i].Extra) {
+			return false, nil
+		}
+		p += len(ops[i].Extra)
+	}
+	if i >= len(ops) {
+		return p == len(b.data), nil
+	}
+
+	if i+1 >= len(ops) {
+		return b.try(i, p, len(b.data))
+	}
+	next := ops[i+1]
+	if next.Type == ngxVariable {
+		a := grammarOf(ops[i].Var)
+		if a == nil {
+			return false, nil
+		}
+		for _, n := range a.lengths(b.data[p:]) {
+			if ok, err := b.try(i, p, p+n); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	for off := p; ; off++ {
+		k := bytes.Index(b.data[off:], next.Extra)
+		if k < 0 {
+			return false, nil
+		}
+		off += k
+		if ok, err := b.try(i, p, off); ok || err != nil {
+			return ok, err
+		}
+	}
+}
+
+// try matches the rest of the line if the value of ops[i] is data[p:end].
+func (b *backtracker) try(i, p, end int) (bool, error) {
+	if b.budget--; b.budget < 0 {
+		return false, &SyntaxError{Msg: ErrBacktrackLimit.Error(), Op: i, Variable: string(b.ngx.ops[i].Extra), Offset: p, Snippet: snippet(b.data, p), Err: ErrBacktrackLimit}
+	}
+	if !b.valid(i, b.data[p:end]) {
+		return false, nil
+	}
+	b.spans[i] = span{p, end}
+	return b.match(i+1, end)
+}
+
+func (b *backtracker) valid(i int, raw []byte) bool {
+	raw, err := b.ngx.esc.Unescape(raw)
+	if err != nil {
+		return false
+	}
+	if v := b.ngx.ops[i].Var; v != nil && string(raw) != b.ngx.esc.Nil() && v.validate(raw) != nil {
+		return false
+	}
+	return b.check(i, raw)
+}
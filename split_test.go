@@ -0,0 +1,44 @@
+package ngx
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBacktrack(t *testing.T) {
+	greedy, err := Compile(`escape=none;$remote_addr "$http_user_agent" $status "$request"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ngx := greedy.WithOptions(Options{Backtrack: true})
+
+	data := `1.2.3.4 "Mozilla "quoted" x" 200 "GET / HTTP/1.1"`
+	if err := greedy.UnmarshalFromString(data, &Access{}); err == nil {
+		t.Fatalf("expecting the greedy decoder to fail on %q", data)
+	}
+	var got Access
+	if err := ngx.UnmarshalFromString(data, &got); err != nil {
+		t.Fatalf("failed to UnmarshalFromString() data %q: %v", data, err)
+	}
+	expected := Access{RemoteAddr: "1.2.3.4", HTTPUserAgent: `Mozilla "quoted" x`, Status: 200, Request: "GET / HTTP/1.1"}
+	if got != expected {
+		t.Fatalf("corrupted data in UnmarshalFromString(): expecting %+v, got %+v", expected, got)
+	}
+
+	m := map[string]string{}
+	if err := ngx.UnmarshalFromString(data, &m); err != nil || m["status"] != "200" || m["http_user_agent"] != `Mozilla "quoted" x` {
+		t.Fatalf("corrupted data in UnmarshalFromString(): got %v, %v", m, err)
+	}
+
+	var field *FieldError
+	if err := ngx.UnmarshalFromString(`1.2.3.4 "x" 2x0 "GET / HTTP/1.1"`, &Access{}); !errors.As(err, &field) || field.Variable != "status" {
+		t.Fatalf("expecting a *FieldError on status, got %v", err)
+	}
+
+	limited := greedy.WithOptions(Options{Backtrack: true, MaxBacktrack: 64})
+	data = `1.2.3.4 "` + strings.Repeat(`" `, 100) + `" x "y"`
+	if err := limited.UnmarshalFromString(data, &Access{}); !errors.Is(err, ErrBacktrackLimit) {
+		t.Fatalf("expecting ErrBacktrackLimit, got %v", err)
+	}
+}
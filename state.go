@@ -0,0 +1,60 @@
+package ngx
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// A decodeState holds what decoding a line needs, reused between lines so
+// that decoding does not allocate.
+type decodeState struct {
+	spans []span
+	w     writer // unescaped value
+	bytes BytesReader
+	str   StringReader
+}
+
+var statePool = &sync.Pool{
+	New: func() interface{} {
+		return new(decodeState)
+	},
+}
+
+// acquireState returns a decodeState with n spans.
+func acquireState(n int) *decodeState {
+	s := statePool.Get().(*decodeState)
+	if cap(s.spans) < n {
+		s.spans = make([]span, n)
+	}
+	s.spans = s.spans[:n]
+	return s
+}
+
+func releaseState(s *decodeState) {
+	s.bytes.buf, s.str.buf = nil, ""
+	if cap(s.w.buf) < 1<<16 {
+		statePool.Put(s)
+	}
+}
+
+// value returns a Reader of the unescaped value of spans[i] in text, valid
+// until the next call. The value shares memory with text if nothing needs
+// unescaping, which codecs only keep if text is a StringReader, through
+// NewString.
+func (s *decodeState) value(esc Esc, text Reader, i int) (Reader, error) {
+	raw := text.Bytes()[s.spans[i].start:s.spans[i].end]
+	if esc.escaped(raw) {
+		s.w.Reset()
+		if err := esc.unescapeTo(&s.w, raw); err != nil {
+			return nil, err
+		}
+		s.bytes.buf = s.w.Bytes()
+		return &s.bytes, nil
+	}
+	if _, ok := text.(*StringReader); ok {
+		s.str.buf, s.str.cap = *(*string)(unsafe.Pointer(&raw)), len(raw)
+		return &s.str, nil
+	}
+	s.bytes.buf = raw
+	return &s.bytes, nil
+}
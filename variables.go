@@ -0,0 +1,240 @@
+package ngx
+
+import (
+	"bytes"
+	"net"
+)
+
+// A grammar describes what the value of a variable looks like, so that the
+// values of two adjacent variables such as "$scheme$host" can be told apart.
+type grammar struct {
+	// lengths returns the lengths of the prefixes of data that are valid
+	// values, longest first.
+	lengths func(data []byte) []int
+	// bounded grammars always end on their own, whatever follows them.
+	bounded bool
+	// chars reports whether ch may appear in a value other than the "-"
+	// nginx writes for a variable without a value.
+	chars func(ch byte) bool
+}
+
+// match reports whether the whole data is a valid value.
+func (g *grammar) match(data []byte) bool {
+	for _, n := range g.lengths(data) {
+		if n == len(data) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isHex(ch byte) bool {
+	return isDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
+func isUpper(ch byte) bool {
+	return ch >= 'A' && ch <= 'Z'
+}
+
+func isHostChar(ch byte) bool {
+	return isDigit(ch) || (ch >= 'a' && ch <= 'z') || isUpper(ch) || ch == '.' || ch == '-' || ch == '_' || ch == ':' || ch == '[' || ch == ']'
+}
+
+// nilLengths handles the "-" nginx writes for a variable without a value.
+func nilLengths(data []byte) []int {
+	if len(data) > 0 && data[0] == '-' {
+		return []int{1}
+	}
+	return nil
+}
+
+// runOf returns a grammar matching non-empty runs of chars, or "-".
+func runOf(chars func(ch byte) bool) *grammar {
+	return &grammar{
+		lengths: func(data []byte) []int {
+			n := 0
+			for n < len(data) && chars(data[n]) {
+				n++
+			}
+			if n == 0 {
+				return nilLengths(data)
+			}
+			lengths := make([]int, 0, n)
+			for ; n > 0; n-- {
+				lengths = append(lengths, n)
+			}
+			return lengths
+		},
+		chars: chars,
+	}
+}
+
+// fixedOf returns a bounded grammar matching any of values, or "-".
+func fixedOf(values ...string) *grammar {
+	return &grammar{
+		lengths: func(data []byte) []int {
+			var lengths []int
+			for _, v := range values {
+				if bytes.HasPrefix(data, []byte(v)) {
+					lengths = append(lengths, len(v))
+				}
+			}
+			if len(lengths) == 0 {
+				return nilLengths(data)
+			}
+			return lengths
+		},
+		bounded: true,
+		chars: func(ch byte) bool {
+			for _, v := range values {
+				if bytes.IndexByte([]byte(v), ch) >= 0 {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// patternOf returns a bounded grammar matching layout, where '0' stands for
+// a digit, 'a' for a letter and '+' for a sign, or "-".
+func patternOf(layout string) *grammar {
+	return &grammar{
+		lengths: func(data []byte) []int {
+			if len(data) < len(layout) {
+				return nilLengths(data)
+			}
+			for i := 0; i < len(layout); i++ {
+				switch ch := data[i]; layout[i] {
+				case '0':
+					if !isDigit(ch) {
+						return nilLengths(data)
+					}
+				case 'a':
+					if !(ch >= 'a' && ch <= 'z') && !isUpper(ch) {
+						return nilLengths(data)
+					}
+				case '+':
+					if ch != '+' && ch != '-' {
+						return nilLengths(data)
+					}
+				default:
+					if ch != layout[i] {
+						return nilLengths(data)
+					}
+				}
+			}
+			return []int{len(layout)}
+		},
+		bounded: true,
+		chars: func(ch byte) bool {
+			return isDigit(ch) || (ch >= 'a' && ch <= 'z') || isUpper(ch) || bytes.IndexByte([]byte(layout), ch) >= 0 ||
+				(ch == '-' && bytes.IndexByte([]byte(layout), '+') >= 0)
+		},
+	}
+}
+
+var (
+	grammarNumber = runOf(isDigit)
+
+	grammarStatus = patternOf("000")
+
+	// seconds with a milliseconds resolution, e.g. 0.003
+	grammarSeconds = &grammar{
+		lengths: func(data []byte) []int {
+			n := 0
+			for n < len(data) && isDigit(data[n]) {
+				n++
+			}
+			if n == 0 || n+4 > len(data) || data[n] != '.' || !isDigit(data[n+1]) || !isDigit(data[n+2]) || !isDigit(data[n+3]) {
+				return nilLengths(data)
+			}
+			return []int{n + 4}
+		},
+		bounded: true,
+		chars: func(ch byte) bool {
+			return isDigit(ch) || ch == '.'
+		},
+	}
+
+	grammarAddr = &grammar{
+		lengths: func(data []byte) []int {
+			if bytes.HasPrefix(data, []byte("unix:")) {
+				return []int{5}
+			}
+			n := 0
+			for n < len(data) && (isHex(data[n]) || data[n] == '.' || data[n] == ':') {
+				n++
+			}
+			var lengths []int
+			for ; n > 0; n-- {
+				if net.ParseIP(string(data[:n])) != nil {
+					lengths = append(lengths, n)
+				}
+			}
+			if len(lengths) == 0 {
+				return nilLengths(data)
+			}
+			return lengths
+		},
+		chars: func(ch byte) bool {
+			return isHex(ch) || ch == '.' || ch == ':'
+		},
+	}
+
+	grammarScheme = fixedOf("https", "http")
+
+	grammarMethod = runOf(func(ch byte) bool {
+		return isUpper(ch) || ch == '_'
+	})
+
+	grammarProtocol = patternOf("HTTP/0.0")
+
+	grammarHost = runOf(isHostChar)
+
+	grammarTimeLocal = patternOf("00/aaa/0000:00:00:00 +0000")
+
+	grammarTimeISO8601 = patternOf("0000-00-00T00:00:00+00:00")
+)
+
+// grammarOf returns the grammar of the values of v, or nil if they may be
+// anything.
+func grammarOf(v *Variable) *grammar {
+	if v != nil && !v.list {
+		return v.grammar
+	}
+	return nil
+}
+
+// adjacentSplittable reports whether the values of the adjacent variables
+// ops[i] and ops[i+1] can be told apart.
+func adjacentSplittable(ops []baseOp, i int) bool {
+	a := grammarOf(ops[i].Var)
+	if a == nil {
+		return false
+	}
+	if a.bounded {
+		return true
+	}
+	b := grammarOf(ops[i+1].Var)
+	if b == nil {
+		return false
+	}
+	overlap := false
+	for ch := 0; ch <= maxLatin1; ch++ {
+		if a.chars(byte(ch)) && b.chars(byte(ch)) {
+			overlap = true
+			break
+		}
+	}
+	if !overlap {
+		return true
+	}
+	// b ends where the following literal (or the line) starts, so that it
+	// decides where a ends.
+	return b.bounded && (i+2 >= len(ops) || ops[i+2].Type != ngxVariable)
+}